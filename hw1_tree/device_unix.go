@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var getDevice = func(file os.FileInfo) (uint64, bool) {
+	if spilled, ok := file.(*spilledFile); ok {
+		return spilled.device, spilled.hasDevice
+	}
+	stat, ok := file.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type entryIterator interface {
+	Next() (os.FileInfo, error)
+	Close() error
+}
+
+type sliceIterator struct {
+	files FileInfoType
+	index int
+}
+
+func (it *sliceIterator) Next() (os.FileInfo, error) {
+	if it.index >= len(it.files) {
+		return nil, io.EOF
+	}
+	file := it.files[it.index]
+	it.index++
+
+	return file, nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+// mergeFanIn is the most runs read at once, so a merge holds a bounded
+// number of open files and buffers however many runs a directory needs.
+const mergeFanIn = 16
+
+// spilledFile is an entry read back from a run. Its stat is spilled together
+// with the name, so an entry removed after the directory was read is still
+// listed the way it was read instead of failing the walk.
+type spilledFile struct {
+	name      string
+	mode      os.FileMode
+	size      int64
+	modTime   time.Time
+	device    uint64
+	hasDevice bool
+}
+
+func (f *spilledFile) Name() string       { return f.name }
+func (f *spilledFile) Size() int64        { return f.size }
+func (f *spilledFile) Mode() os.FileMode  { return f.mode }
+func (f *spilledFile) ModTime() time.Time { return f.modTime }
+func (f *spilledFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *spilledFile) Sys() interface{}   { return nil }
+
+// spillRecord keeps the name last, since only it may contain spaces.
+func spillRecord(file os.FileInfo) string {
+	device := "-"
+	if fileDevice, ok := getDevice(file); ok {
+		device = strconv.FormatUint(fileDevice, 10)
+	}
+
+	return fmt.Sprintf("%d %d %d %s %s", uint32(file.Mode()), file.Size(), file.ModTime().UnixNano(), device, file.Name())
+}
+
+func parseRecord(record string) (*spilledFile, error) {
+	fields := strings.SplitN(record, " ", 5)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("[parseRecord]: Error read temporary file")
+	}
+	mode, errMode := strconv.ParseUint(fields[0], 10, 32)
+	size, errSize := strconv.ParseInt(fields[1], 10, 64)
+	modTime, errTime := strconv.ParseInt(fields[2], 10, 64)
+	if errMode != nil || errSize != nil || errTime != nil {
+		return nil, fmt.Errorf("[parseRecord]: Error read temporary file")
+	}
+
+	file := &spilledFile{name: fields[4], mode: os.FileMode(mode), size: size, modTime: time.Unix(0, modTime)}
+	if fields[3] != "-" {
+		device, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("[parseRecord]: Error read temporary file")
+		}
+		file.device, file.hasDevice = device, true
+	}
+
+	return file, nil
+}
+
+// recordName is the sort key of a record.
+func recordName(record string) string {
+	fields := strings.SplitN(record, " ", 5)
+	return fields[len(fields)-1]
+}
+
+// sortedRun is a chunk of sorted entry records spilled to a temporary file,
+// separated by NUL bytes since those can not appear in file names. A run is
+// only kept open while it is being merged.
+type sortedRun struct {
+	file   *os.File
+	reader *bufio.Reader
+	record string
+	name   string
+}
+
+func (run *sortedRun) advance() error {
+	record, err := run.reader.ReadString(0)
+	if err != nil {
+		return err
+	}
+	run.record = record[:len(record)-1]
+	run.name = recordName(run.record)
+
+	return nil
+}
+
+func (run *sortedRun) remove() {
+	run.file.Close()
+	os.Remove(run.file.Name())
+}
+
+func openRun(path string) (*sortedRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("[openRun]: Error open temporary file")
+	}
+	run := &sortedRun{file: file, reader: bufio.NewReader(file)}
+	if err := run.advance(); err != nil {
+		run.remove()
+		return nil, fmt.Errorf("[openRun]: Error read temporary file")
+	}
+
+	return run, nil
+}
+
+func writeRecords(next func() (string, error)) (string, error) {
+	file, err := ioutil.TempFile("", "tree-run-")
+	if err != nil {
+		return "", fmt.Errorf("[writeRecords]: Error create temporary file")
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			writer.WriteString(record)
+			err = writer.WriteByte(0)
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return "", err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("[writeRecords]: Error write temporary file")
+	}
+
+	return file.Name(), nil
+}
+
+func writeRun(chunk FileInfoType) (string, error) {
+	sort.Sort(chunk)
+	index := 0
+	return writeRecords(func() (string, error) {
+		if index >= len(chunk) {
+			return "", io.EOF
+		}
+		index++
+		return spillRecord(chunk[index-1]), nil
+	})
+}
+
+type runHeap []*sortedRun
+
+func (h runHeap) Len() int {
+	return len(h)
+}
+
+func (h runHeap) Less(i, j int) bool {
+	return h[i].name < h[j].name
+}
+
+func (h runHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*sortedRun))
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return run
+}
+
+// runMerger yields the records of up to mergeFanIn runs in sorted order.
+type runMerger struct {
+	runs runHeap
+	all  []*sortedRun
+}
+
+func openRuns(paths []string) (*runMerger, error) {
+	merger := &runMerger{}
+	for index, path := range paths {
+		run, err := openRun(path)
+		if err != nil {
+			merger.close()
+			for _, path := range paths[index:] {
+				os.Remove(path)
+			}
+			return nil, err
+		}
+		merger.all = append(merger.all, run)
+	}
+	merger.runs = append(runHeap(nil), merger.all...)
+	heap.Init(&merger.runs)
+
+	return merger, nil
+}
+
+func (merger *runMerger) next() (string, error) {
+	if len(merger.runs) == 0 {
+		return "", io.EOF
+	}
+
+	run := merger.runs[0]
+	record := run.record
+	err := run.advance()
+	switch {
+	case err == io.EOF:
+		heap.Pop(&merger.runs)
+	case err != nil:
+		return "", fmt.Errorf("[runMerger]: Error read temporary file")
+	default:
+		heap.Fix(&merger.runs, 0)
+	}
+
+	return record, nil
+}
+
+func (merger *runMerger) close() {
+	for _, run := range merger.all {
+		run.remove()
+	}
+	merger.runs = nil
+	merger.all = nil
+}
+
+// mergeRuns replaces the runs with a single one.
+func mergeRuns(paths []string) (string, error) {
+	merger, err := openRuns(paths)
+	if err != nil {
+		return "", err
+	}
+	defer merger.close()
+
+	return writeRecords(merger.next)
+}
+
+// runLevels merges every mergeFanIn runs of one level into a run of the next
+// level, so the number of runs kept stays logarithmic in the directory size
+// and every name is rewritten once per level.
+type runLevels [][]string
+
+func (levels *runLevels) add(path string) error {
+	for level := 0; ; level++ {
+		if level == len(*levels) {
+			*levels = append(*levels, nil)
+		}
+		(*levels)[level] = append((*levels)[level], path)
+		if len((*levels)[level]) < mergeFanIn {
+			return nil
+		}
+
+		merged, err := mergeRuns((*levels)[level])
+		(*levels)[level] = nil
+		if err != nil {
+			return err
+		}
+		path = merged
+	}
+}
+
+// merge leaves at most mergeFanIn runs in total.
+func (levels *runLevels) merge() ([]string, error) {
+	var paths []string
+	for _, level := range *levels {
+		paths = append(paths, level...)
+	}
+	*levels = nil
+
+	for len(paths) > mergeFanIn {
+		merged, err := mergeRuns(paths[:mergeFanIn])
+		if err != nil {
+			for _, path := range paths[mergeFanIn:] {
+				os.Remove(path)
+			}
+			return nil, err
+		}
+		paths = append(paths[mergeFanIn:], merged)
+	}
+
+	return paths, nil
+}
+
+func (levels *runLevels) remove() {
+	for _, level := range *levels {
+		for _, path := range level {
+			os.Remove(path)
+		}
+	}
+	*levels = nil
+}
+
+type mergeIterator struct {
+	merger *runMerger
+}
+
+func (it *mergeIterator) Next() (os.FileInfo, error) {
+	record, err := it.merger.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRecord(record)
+}
+
+func (it *mergeIterator) Close() error {
+	it.merger.close()
+
+	return nil
+}
+
+// getChunkedEntries reads a directory chunkSize entries at a time and sorts it
+// with an external merge sort, so only about one chunk is held in memory.
+// Once a directory has more entries than opts.fileLimit it is only counted,
+// since it is going to be collapsed anyway.
+func getChunkedEntries(path string, opts treeOptions) (entryIterator, int, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[getChunkedEntries]: Error open directory")
+	}
+	defer dir.Close()
+
+	var levels runLevels
+	chunk := make(FileInfoType, 0, opts.chunkSize)
+	count := 0
+
+	for {
+		filesInfo, err := dir.Readdir(opts.chunkSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			levels.remove()
+			return nil, 0, fmt.Errorf("[getChunkedEntries]: Error read directory")
+		}
+
+		filesInfo = getFilesForPrint(filesInfo, opts)
+		count += len(filesInfo)
+		if opts.fileLimit > 0 && count > opts.fileLimit {
+			levels.remove()
+			chunk = chunk[:0]
+			continue
+		}
+
+		chunk = append(chunk, filesInfo...)
+		if len(chunk) < opts.chunkSize {
+			continue
+		}
+
+		run, err := writeRun(chunk)
+		if err == nil {
+			err = levels.add(run)
+		}
+		if err != nil {
+			levels.remove()
+			return nil, 0, err
+		}
+		chunk = chunk[:0]
+	}
+
+	if opts.fileLimit > 0 && count > opts.fileLimit {
+		return &sliceIterator{}, count, nil
+	}
+	if len(levels) == 0 {
+		sort.Sort(chunk)
+		return &sliceIterator{files: chunk}, count, nil
+	}
+
+	if len(chunk) > 0 {
+		run, err := writeRun(chunk)
+		if err == nil {
+			err = levels.add(run)
+		}
+		if err != nil {
+			levels.remove()
+			return nil, 0, err
+		}
+	}
+
+	paths, err := levels.merge()
+	if err != nil {
+		return nil, 0, err
+	}
+	merger, err := openRuns(paths)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &mergeIterator{merger: merger}, count, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeWideDir(t *testing.T, count int) string {
+	root := t.TempDir()
+	for i := 0; i < count; i++ {
+		if err := ioutil.WriteFile(filepath.Join(root, fmt.Sprintf("file%04d", (i*37)%count)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestChunkedEntriesManyRuns(t *testing.T) {
+	const count = 600
+	root := makeWideDir(t, count)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	// 300 runs go through two levels of merges
+	entries, total, err := getChunkedEntries(root, treeOptions{printFiles: true, chunkSize: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total != count {
+		t.Errorf("expected %d entries, got %d", count, total)
+	}
+	if runs, _ := ioutil.ReadDir(tmp); len(runs) > mergeFanIn {
+		t.Errorf("expected at most %d runs left for the final merge, got %d", mergeFanIn, len(runs))
+	}
+
+	for i := 0; ; i++ {
+		file, err := entries.Next()
+		if err == io.EOF {
+			if i != count {
+				t.Errorf("expected %d entries, got %d", count, i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := fmt.Sprintf("file%04d", i); file.Name() != expected {
+			t.Fatalf("expected %s, got %s", expected, file.Name())
+		}
+	}
+
+	entries.Close()
+	if runs, _ := ioutil.ReadDir(tmp); len(runs) != 0 {
+		t.Errorf("expected temporary files to be removed, got %d", len(runs))
+	}
+}
+
+func TestChunkedEntriesFileLimit(t *testing.T) {
+	root := makeWideDir(t, 50)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	entries, total, err := getChunkedEntries(root, treeOptions{printFiles: true, chunkSize: 2, fileLimit: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer entries.Close()
+
+	if total != 50 {
+		t.Errorf("expected 50 entries, got %d", total)
+	}
+	if runs, _ := ioutil.ReadDir(tmp); len(runs) != 0 {
+		t.Errorf("expected no runs left for a collapsed directory, got %d", len(runs))
+	}
+}
+
+func TestChunkedEntriesRemovedAfterRead(t *testing.T) {
+	root := makeWideDir(t, 10)
+	if err := ioutil.WriteFile(filepath.Join(root, "file0003"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+
+	entries, total, err := getChunkedEntries(root, treeOptions{printFiles: true, chunkSize: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer entries.Close()
+	for _, name := range []string{"file0003", "file0009"} {
+		if err := os.Remove(filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < total; i++ {
+		file, err := entries.Next()
+		if err != nil {
+			t.Fatalf("entry %d: unexpected error: %v", i, err)
+		}
+		if expected := fmt.Sprintf("file%04d", i); file.Name() != expected || file.IsDir() {
+			t.Fatalf("expected file %s, got %s", expected, file.Name())
+		}
+		if i == 3 && file.Size() != 4 {
+			t.Errorf("expected the size read with the directory, got %d", file.Size())
+		}
+	}
+	if _, err := entries.Next(); err != io.EOF {
+		t.Errorf("expected %d entries, got more: %v", total, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type gitStatus struct {
+	root   string
+	prefix string
+	files  map[string][]string
+	dirs   map[string][]string
+}
+
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).Output()
+}
+
+// loadGitStatus returns nil when root is not inside a git working copy.
+func loadGitStatus(ctx context.Context, root string) (*gitStatus, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil
+	}
+	prefix, err := runGit(ctx, root, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, nil
+	}
+	output, err := runGit(ctx, root, "status", "--porcelain=v1", "-z", "--ignored", "--", ".")
+	if err != nil {
+		return nil, fmt.Errorf("[loadGitStatus]: Error run git status")
+	}
+
+	status := &gitStatus{
+		root:   root,
+		prefix: strings.TrimSuffix(strings.TrimSpace(string(prefix)), "/"),
+		files:  make(map[string][]string),
+		dirs:   make(map[string][]string),
+	}
+	status.parse(output)
+
+	return status, nil
+}
+
+func (s *gitStatus) parse(output []byte) {
+	records := strings.Split(string(output), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+		x, y, name := record[0], record[1], record[3:]
+		if x == 'R' || x == 'C' {
+			// renames and copies are followed by the original path
+			i++
+		}
+
+		notes := getGitNotes(x, y)
+		if strings.HasSuffix(name, "/") {
+			s.dirs[strings.TrimSuffix(name, "/")] = notes
+			continue
+		}
+		s.files[name] = notes
+	}
+}
+
+func getGitNotes(x, y byte) []string {
+	switch {
+	case x == '?' && y == '?':
+		return []string{"untracked"}
+	case x == '!' && y == '!':
+		return []string{"ignored"}
+	}
+
+	var notes []string
+	if x != ' ' {
+		notes = append(notes, "staged")
+	}
+	if y != ' ' {
+		notes = append(notes, "modified")
+	}
+
+	return notes
+}
+
+func (s *gitStatus) getNotes(entryPath string) []string {
+	rel, err := filepath.Rel(s.root, entryPath)
+	if err != nil {
+		return nil
+	}
+	name := path.Join(s.prefix, filepath.ToSlash(rel))
+
+	if notes, ok := s.files[name]; ok {
+		return notes
+	}
+	for dir := name; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if notes, ok := s.dirs[dir]; ok {
+			return notes
+		}
+	}
+
+	return nil
+}
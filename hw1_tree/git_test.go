@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTreeGitStatus(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if output, err := runGit(context.Background(), root, args...); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write(".gitignore", "*.log\n")
+	write("clean.txt", "a")
+	write("changed.txt", "a")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	write("changed.txt", "ab")
+	write("added.txt", "a")
+	git("add", "added.txt")
+	write("new.txt", "a")
+	write("debug.log", "a")
+
+	expected := `├───added.txt (1b) [staged]
+├───changed.txt (2b) [modified]
+├───clean.txt (1b)
+├───debug.log (1b) [ignored]
+└───new.txt (1b) [untracked]
+`
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, root, treeOptions{printFiles: true, gitStatus: true})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != expected {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
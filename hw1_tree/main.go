@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 )
 
 type FileInfoType []os.FileInfo
@@ -22,6 +28,25 @@ func (a FileInfoType) Less(i, j int) bool {
 	return a[i].Name() < a[j].Name()
 }
 
+type treeOptions struct {
+	printFiles bool
+	showHidden bool
+	classify   bool
+
+	oneFileSystem bool
+	markMounts    bool
+
+	fileLimit int
+	chunkSize int
+	maxDepth  int
+
+	format    string
+	gitStatus bool
+
+	timeout     time.Duration
+	interactive bool
+}
+
 func getFilesInfo(path string) (FileInfoType, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -37,13 +62,20 @@ func getFilesInfo(path string) (FileInfoType, error) {
 	return fileInfo, nil
 }
 
-func getFilesForPrint(filesInfo FileInfoType, printFiles bool) FileInfoType {
-	if printFiles {
+func isHidden(file os.FileInfo) bool {
+	return strings.HasPrefix(file.Name(), ".")
+}
+
+func getFilesForPrint(filesInfo FileInfoType, opts treeOptions) FileInfoType {
+	if opts.printFiles && opts.showHidden {
 		return filesInfo
 	}
 	var resultFileInfo = make(FileInfoType, 0, cap(filesInfo))
 	for _, file := range filesInfo {
-		if file.IsDir() {
+		if !opts.showHidden && isHidden(file) {
+			continue
+		}
+		if opts.printFiles || file.IsDir() {
 			resultFileInfo = append(resultFileInfo, file)
 		}
 	}
@@ -51,7 +83,64 @@ func getFilesForPrint(filesInfo FileInfoType, printFiles bool) FileInfoType {
 	return resultFileInfo
 }
 
+func getSortedEntries(path string, opts treeOptions) (entryIterator, int, error) {
+	if opts.chunkSize > 0 {
+		return getChunkedEntries(path, opts)
+	}
+
+	filesInfo, err := getFilesInfo(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	filesInfo = getFilesForPrint(filesInfo, opts)
+	sort.Sort(filesInfo)
+
+	return &sliceIterator{files: filesInfo}, len(filesInfo), nil
+}
+
+// getSortedEntriesContext stops waiting for a directory read once ctx is done,
+// so a hung mount does not block the walk. The abandoned read is cleaned up
+// in the background when it eventually returns.
+func getSortedEntriesContext(ctx context.Context, path string, opts treeOptions) (entryIterator, int, error) {
+	if ctx.Done() == nil {
+		return getSortedEntries(path, opts)
+	}
+
+	type sortedEntries struct {
+		entries entryIterator
+		count   int
+		err     error
+	}
+	done := make(chan sortedEntries, 1)
+	go func() {
+		entries, count, err := getSortedEntries(path, opts)
+		done <- sortedEntries{entries, count, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.entries, result.count, result.err
+	case <-ctx.Done():
+		go func() {
+			if result := <-done; result.entries != nil {
+				result.entries.Close()
+			}
+		}()
+		return nil, 0, ctx.Err()
+	}
+}
+
 func getSize(file os.FileInfo) string {
+	mode := file.Mode()
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	}
+
 	if file.Size() == 0 {
 		return "empty"
 	}
@@ -59,6 +148,41 @@ func getSize(file os.FileInfo) string {
 	return fmt.Sprint(file.Size()) + "b"
 }
 
+func getTypeMarker(file os.FileInfo) string {
+	mode := file.Mode()
+	switch {
+	case mode.IsDir():
+		return "/"
+	case mode&os.ModeSymlink != 0:
+		return "@"
+	case mode&os.ModeNamedPipe != 0:
+		return "|"
+	case mode&os.ModeSocket != 0:
+		return "="
+	case mode.IsRegular() && mode&0111 != 0:
+		return "*"
+	}
+
+	return ""
+}
+
+func getFileName(file os.FileInfo, opts treeOptions) string {
+	if opts.classify {
+		return file.Name() + getTypeMarker(file)
+	}
+
+	return file.Name()
+}
+
+func getNotes(notes []string) string {
+	result := ""
+	for _, note := range notes {
+		result += " [" + note + "]"
+	}
+
+	return result
+}
+
 func printDir(output io.Writer, result string, fileName string, isLastFile bool) {
 	if isLastFile {
 		fmt.Fprintf(output, result+"└───%s\n", fileName)
@@ -67,63 +191,243 @@ func printDir(output io.Writer, result string, fileName string, isLastFile bool)
 	fmt.Fprintf(output, result+"├───%s\n", fileName)
 }
 
-func printFile(output io.Writer, result string, file os.FileInfo, isLastFile bool) {
-	size := getSize(file)
-	if isLastFile {
-		fmt.Fprintf(output, result+"└───%s (%s)\n", file.Name(), size)
+func printFile(output io.Writer, result string, entry treeEntry) {
+	size := getSize(entry.file)
+	notes := getNotes(entry.notes)
+	if entry.isLast {
+		fmt.Fprintf(output, result+"└───%s (%s)%s\n", entry.name, size, notes)
 		return
 	}
-	fmt.Fprintf(output, result+"├───%s (%s)\n", file.Name(), size)
+	fmt.Fprintf(output, result+"├───%s (%s)%s\n", entry.name, size, notes)
 }
 
-func getResultTree(output io.Writer, path string, printFiles bool, result string) (err error) {
-	filesInfo, err := getFilesInfo(path)
-	if err != nil {
-		return err
+func printOmitted(output io.Writer, result string, count int) {
+	fmt.Fprintf(output, result+"└───[%d entries omitted]\n", count)
+}
+
+func printInterrupted(output io.Writer, result string) {
+	fmt.Fprint(output, result+"└───[walk interrupted]\n")
+}
+
+type treeEntry struct {
+	file   os.FileInfo
+	path   string
+	name   string
+	notes  []string
+	depth  int
+	isLast bool
+}
+
+type treeWalker struct {
+	ctx      context.Context
+	renderer treeRenderer
+	opts     treeOptions
+	git      *gitStatus
+}
+
+func (w *treeWalker) newEntry(path string, file os.FileInfo, depth int, isLast bool, device uint64) (treeEntry, uint64, bool) {
+	entry := treeEntry{
+		file:   file,
+		path:   filepath.Join(path, file.Name()),
+		name:   getFileName(file, w.opts),
+		depth:  depth,
+		isLast: isLast,
+	}
+	if w.git != nil {
+		entry.notes = append(entry.notes, w.git.getNotes(entry.path)...)
+	}
+	if !file.IsDir() {
+		return entry, device, false
+	}
+
+	fileDevice, ok := getDevice(file)
+	isMount := ok && fileDevice != device
+	if w.opts.markMounts && isMount {
+		entry.notes = append(entry.notes, "mount point")
 	}
-	filesInfo = getFilesForPrint(filesInfo, printFiles)
+
+	return entry, fileDevice, !(w.opts.oneFileSystem && isMount)
+}
+
+func (w *treeWalker) getResultTree(path string, depth int, device uint64) (err error) {
+	entries, count, err := getSortedEntriesContext(w.ctx, path, w.opts)
 	if err != nil {
+		if w.ctx.Err() != nil {
+			w.renderer.interrupted(depth)
+		}
 		return err
 	}
+	defer entries.Close()
 
-	sort.Sort(filesInfo)
-	indexLastFile := len(filesInfo) - 1
+	if w.opts.fileLimit > 0 && count > w.opts.fileLimit {
+		w.renderer.omitted(depth, count)
+		return nil
+	}
 
-	for indexFile, file := range filesInfo {
-		var isLastFile bool = indexLastFile == indexFile
+	for indexFile := 0; indexFile < count; indexFile++ {
+		if err := w.ctx.Err(); err != nil {
+			w.renderer.interrupted(depth)
+			return err
+		}
+		file, err := entries.Next()
+		if err != nil {
+			return err
+		}
+		entry, fileDevice, descend := w.newEntry(path, file, depth, indexFile == count-1, device)
 
 		if file.IsDir() {
-
-			printDir(output, result, file.Name(), isLastFile)
-
-			if isLastFile {
-				return getResultTree(output, filepath.Join(path, file.Name()), printFiles, result+"\t")
+			w.renderer.enterDir(entry)
+			if descend && (w.opts.maxDepth == 0 || depth+1 < w.opts.maxDepth) {
+				err = w.getResultTree(entry.path, depth+1, fileDevice)
 			}
-
-			err = getResultTree(output, filepath.Join(path, file.Name()), printFiles, result+"│\t")
+			w.renderer.leaveDir(entry)
 			if err != nil {
 				return err
 			}
 
-		} else if printFiles {
-			printFile(output, result, file, isLastFile)
+		} else if w.opts.printFiles {
+			w.renderer.file(entry)
 		}
 	}
 	return nil
 }
 
 func dirTree(output io.Writer, path string, printFiles bool) (err error) {
-	return getResultTree(output, path, printFiles, "")
+	return dirTreeOptions(output, path, treeOptions{printFiles: printFiles, showHidden: true})
+}
+
+func dirTreeOptions(output io.Writer, path string, opts treeOptions) (err error) {
+	return dirTreeContext(context.Background(), output, path, opts)
+}
+
+// dirTreeContext stops the walk when ctx is done, leaving a partial tree
+// that ends with a "walk interrupted" marker, and returns ctx.Err().
+func dirTreeContext(ctx context.Context, output io.Writer, path string, opts treeOptions) (err error) {
+	renderer, err := newRenderer(output, opts.format)
+	if err != nil {
+		return err
+	}
+
+	return renderTree(ctx, renderer, path, opts)
+}
+
+func renderTree(ctx context.Context, renderer treeRenderer, path string, opts treeOptions) (err error) {
+	root, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("[dirTree]: Error stat root directory")
+	}
+	device, _ := getDevice(root)
+
+	walker := &treeWalker{ctx: ctx, renderer: renderer, opts: opts}
+	if opts.gitStatus {
+		walker.git, err = loadGitStatus(ctx, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	renderer.begin(path)
+	err = walker.getResultTree(path, 0, device)
+	renderer.end()
+
+	return err
+}
+
+func newFlagSet(name string, usage string, opts *treeOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&opts.printFiles, "f", false, "print files")
+	flags.BoolVar(&opts.showHidden, "a", false, "print hidden entries")
+	flags.BoolVar(&opts.classify, "F", false, "append type markers (/ * @ | =) like ls -F")
+	flags.BoolVar(&opts.oneFileSystem, "x", false, "stay on the root filesystem (same as --one-file-system)")
+	flags.BoolVar(&opts.oneFileSystem, "one-file-system", false, "do not descend into directories on other filesystems")
+	flags.BoolVar(&opts.markMounts, "mounts", false, "annotate mount points")
+	flags.IntVar(&opts.fileLimit, "filelimit", 0, "collapse directories with more than N entries")
+	flags.IntVar(&opts.chunkSize, "chunk", 0, "read directories N entries at a time and sort them on disk")
+	flags.IntVar(&opts.maxDepth, "depth", 0, "descend at most N levels")
+	flags.BoolVar(&opts.gitStatus, "git", false, "annotate entries with their git status")
+	flags.DurationVar(&opts.timeout, "timeout", 0, "stop the walk after this long and print a partial tree; with -i, limit every directory load and search")
+
+	return flags
+}
+
+// parsePath allows the path to come before the flags, as in "main.go . -f".
+func parsePath(flags *flag.FlagSet, args []string) (string, error) {
+	path := ""
+	for {
+		if err := flags.Parse(args); err != nil {
+			return "", err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		if path != "" {
+			flags.Usage()
+			return "", fmt.Errorf("[parsePath]: unexpected argument %q", flags.Arg(0))
+		}
+		path = flags.Arg(0)
+		args = flags.Args()[1:]
+	}
+	if path == "" {
+		path = "."
+	}
+
+	return path, nil
+}
+
+func parseArgs(args []string) (string, treeOptions, error) {
+	var opts treeOptions
+
+	flags := newFlagSet("tree", "usage go run main.go . [-f] [-a] [-F] [-x] [--mounts] [--filelimit N] [--chunk N] [--depth N] [--format text|markdown|dot|json|html] [--git] [--timeout 10s] [-i]\n      go run main.go serve [--addr :8080] . [flags]", &opts)
+	flags.StringVar(&opts.format, "format", "text", "output format: text, markdown, dot, json or html")
+	flags.BoolVar(&opts.interactive, "i", false, "browse the tree interactively")
+
+	path, err := parsePath(flags, args)
+
+	return path, opts, err
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		addr, root, opts, maxSize, err := parseServeArgs(os.Args[2:])
+		if err != nil {
+			os.Exit(2)
+		}
+		err = runServer(addr, root, opts, maxSize)
+		if err != nil {
+			panic(err.Error())
+		}
+		return
+	}
+
 	out := os.Stdout
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+	path, opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// the TUI applies --timeout to every load and search instead
+	if opts.timeout > 0 && !opts.interactive {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	if opts.interactive {
+		err = runTUI(ctx, path, opts)
+	} else {
+		err = dirTreeContext(ctx, out, path, opts)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "tree: walk interrupted:", err)
+		stop()
+		os.Exit(1)
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	err := dirTree(out, path, printFiles)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"os"
 	"testing"
 )
 
@@ -73,3 +75,143 @@ func TestTreeDir(t *testing.T) {
 		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDirResult)
 	}
 }
+
+const testOneFileSystemResult = `├───project
+├───static [mount point]
+└───zline
+	└───lorem
+		└───ipsum
+`
+
+func TestTreeOneFileSystem(t *testing.T) {
+	defaultGetDevice := getDevice
+	defer func() { getDevice = defaultGetDevice }()
+	getDevice = func(file os.FileInfo) (uint64, bool) {
+		if file.Name() == "static" {
+			return 2, true
+		}
+		return 1, true
+	}
+
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, "testdata", treeOptions{oneFileSystem: true, markMounts: true})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testOneFileSystemResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testOneFileSystemResult)
+	}
+}
+
+const testFileLimitResult = `├───project
+│	├───file.txt (19b)
+│	└───gopher.png (70372b)
+├───static
+│	└───[6 entries omitted]
+├───zline
+│	├───empty.txt (empty)
+│	└───lorem
+│		├───dolor.txt (empty)
+│		├───gopher.png (70372b)
+│		└───ipsum
+│			└───gopher.png (70372b)
+└───zzfile.txt (empty)
+`
+
+func TestTreeFileLimit(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, "testdata", treeOptions{printFiles: true, fileLimit: 4})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testFileLimitResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testFileLimitResult)
+	}
+}
+
+func TestTreeChunked(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, "testdata", treeOptions{printFiles: true, showHidden: true, chunkSize: 2})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testFullResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testFullResult)
+	}
+}
+
+const testMarkdownResult = "- `empty.txt` (empty)\n" +
+	"- `lorem/`\n" +
+	"  - `dolor.txt` (empty)\n" +
+	"  - `gopher.png` (70372b)\n" +
+	"  - `ipsum/`\n" +
+	"    - `gopher.png` (70372b)\n"
+
+func TestTreeMarkdown(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, "testdata/zline", treeOptions{printFiles: true, format: "markdown"})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testMarkdownResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testMarkdownResult)
+	}
+}
+
+const testDotResult = `digraph tree {
+	rankdir=LR;
+	node [shape=note];
+	n0 [label="testdata/zline", shape=folder];
+	n1 [label="lorem", shape=folder];
+	n0 -> n1;
+	n2 [label="ipsum", shape=folder];
+	n1 -> n2;
+}
+`
+
+func TestTreeDot(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, "testdata/zline", treeOptions{format: "dot"})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testDotResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDotResult)
+	}
+}
+
+type cancelRenderer struct {
+	*textRenderer
+	cancel context.CancelFunc
+}
+
+func (r *cancelRenderer) file(entry treeEntry) {
+	r.textRenderer.file(entry)
+	r.cancel()
+}
+
+const testInterruptedResult = `├───project
+│	├───file.txt (19b)
+│	└───[walk interrupted]
+`
+
+func TestTreeInterrupted(t *testing.T) {
+	out := new(bytes.Buffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	renderer := &cancelRenderer{textRenderer: &textRenderer{output: out}, cancel: cancel}
+
+	err := renderTree(ctx, renderer, "testdata", treeOptions{printFiles: true})
+	if err != context.Canceled {
+		t.Errorf("test for interrupt Failed - expected context.Canceled, got %v", err)
+	}
+	result := out.String()
+	if result != testInterruptedResult {
+		t.Errorf("test for interrupt Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testInterruptedResult)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+type treeRenderer interface {
+	begin(root string)
+	enterDir(entry treeEntry)
+	leaveDir(entry treeEntry)
+	file(entry treeEntry)
+	omitted(depth int, count int)
+	interrupted(depth int)
+	end()
+}
+
+func newRenderer(output io.Writer, format string) (treeRenderer, error) {
+	switch format {
+	case "", "text":
+		return &textRenderer{output: output}, nil
+	case "markdown", "md":
+		return &markdownRenderer{output: output}, nil
+	case "dot":
+		return &dotRenderer{output: output}, nil
+	case "json":
+		return &jsonRenderer{output: output}, nil
+	case "html":
+		return &htmlRenderer{output: output}, nil
+	}
+
+	return nil, fmt.Errorf("[newRenderer]: unknown format %q", format)
+}
+
+type textRenderer struct {
+	output   io.Writer
+	prefixes []string
+}
+
+func (r *textRenderer) prefix() string {
+	return strings.Join(r.prefixes, "")
+}
+
+func (r *textRenderer) begin(root string) {}
+
+func (r *textRenderer) enterDir(entry treeEntry) {
+	printDir(r.output, r.prefix(), entry.name+getNotes(entry.notes), entry.isLast)
+	if entry.isLast {
+		r.prefixes = append(r.prefixes, "\t")
+		return
+	}
+	r.prefixes = append(r.prefixes, "│\t")
+}
+
+func (r *textRenderer) leaveDir(entry treeEntry) {
+	r.prefixes = r.prefixes[:len(r.prefixes)-1]
+}
+
+func (r *textRenderer) file(entry treeEntry) {
+	printFile(r.output, r.prefix(), entry)
+}
+
+func (r *textRenderer) omitted(depth int, count int) {
+	printOmitted(r.output, r.prefix(), count)
+}
+
+func (r *textRenderer) interrupted(depth int) {
+	printInterrupted(r.output, r.prefix())
+}
+
+func (r *textRenderer) end() {}
+
+type markdownRenderer struct {
+	output io.Writer
+}
+
+func (r *markdownRenderer) indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
+
+func (r *markdownRenderer) notes(notes []string) string {
+	result := ""
+	for _, note := range notes {
+		result += " _" + note + "_"
+	}
+
+	return result
+}
+
+func (r *markdownRenderer) begin(root string) {}
+
+func (r *markdownRenderer) enterDir(entry treeEntry) {
+	name := entry.name
+	if !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+	fmt.Fprintf(r.output, "%s- `%s`%s\n", r.indent(entry.depth), name, r.notes(entry.notes))
+}
+
+func (r *markdownRenderer) leaveDir(entry treeEntry) {}
+
+func (r *markdownRenderer) file(entry treeEntry) {
+	fmt.Fprintf(r.output, "%s- `%s` (%s)%s\n", r.indent(entry.depth), entry.name, getSize(entry.file), r.notes(entry.notes))
+}
+
+func (r *markdownRenderer) omitted(depth int, count int) {
+	fmt.Fprintf(r.output, "%s- _%d entries omitted_\n", r.indent(depth), count)
+}
+
+func (r *markdownRenderer) interrupted(depth int) {
+	fmt.Fprintf(r.output, "%s- _walk interrupted_\n", r.indent(depth))
+}
+
+func (r *markdownRenderer) end() {}
+
+var dotReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type dotRenderer struct {
+	output  io.Writer
+	nextID  int
+	parents []int
+}
+
+func (r *dotRenderer) node(label string, attrs string) int {
+	id := r.nextID
+	r.nextID++
+	fmt.Fprintf(r.output, "\tn%d [label=\"%s\"%s];\n", id, dotReplacer.Replace(label), attrs)
+	if len(r.parents) > 0 {
+		fmt.Fprintf(r.output, "\tn%d -> n%d;\n", r.parents[len(r.parents)-1], id)
+	}
+
+	return id
+}
+
+func (r *dotRenderer) begin(root string) {
+	fmt.Fprintln(r.output, "digraph tree {")
+	fmt.Fprintln(r.output, "\trankdir=LR;")
+	fmt.Fprintln(r.output, "\tnode [shape=note];")
+	r.parents = append(r.parents, r.node(root, ", shape=folder"))
+}
+
+func (r *dotRenderer) enterDir(entry treeEntry) {
+	r.parents = append(r.parents, r.node(entry.name+getNotes(entry.notes), ", shape=folder"))
+}
+
+func (r *dotRenderer) leaveDir(entry treeEntry) {
+	r.parents = r.parents[:len(r.parents)-1]
+}
+
+func (r *dotRenderer) file(entry treeEntry) {
+	r.node(entry.name+" ("+getSize(entry.file)+")"+getNotes(entry.notes), "")
+}
+
+func (r *dotRenderer) omitted(depth int, count int) {
+	r.node(fmt.Sprintf("[%d entries omitted]", count), ", shape=plaintext")
+}
+
+func (r *dotRenderer) interrupted(depth int) {
+	r.node("[walk interrupted]", ", shape=plaintext")
+}
+
+func (r *dotRenderer) end() {
+	fmt.Fprintln(r.output, "}")
+}
+
+// getDisplayPath hides everything above base, so a server does not expose
+// the absolute location of its root.
+func getDisplayPath(base string, path string) string {
+	if base == "" {
+		return path
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+type jsonNode struct {
+	Name  string   `json:"name,omitempty"`
+	Path  string   `json:"path,omitempty"`
+	Type  string   `json:"type"`
+	Size  *int64   `json:"size,omitempty"`
+	Count int      `json:"count,omitempty"`
+	Notes []string `json:"notes,omitempty"`
+}
+
+type jsonRenderer struct {
+	output io.Writer
+	base   string
+	first  []bool
+}
+
+// open writes a node without its closing brace, so that children can follow.
+func (r *jsonRenderer) open(node jsonNode) {
+	if len(r.first) > 0 {
+		if !r.first[len(r.first)-1] {
+			fmt.Fprint(r.output, ",")
+		}
+		r.first[len(r.first)-1] = false
+	}
+	data, _ := json.Marshal(node)
+	r.output.Write(data[:len(data)-1])
+}
+
+func (r *jsonRenderer) entryNode(entry treeEntry, nodeType string) jsonNode {
+	return jsonNode{
+		Name:  entry.name,
+		Path:  getDisplayPath(r.base, entry.path),
+		Type:  nodeType,
+		Notes: entry.notes,
+	}
+}
+
+func (r *jsonRenderer) begin(root string) {
+	r.open(jsonNode{Name: getDisplayPath(r.base, root), Path: getDisplayPath(r.base, root), Type: "directory"})
+	fmt.Fprint(r.output, `,"children":[`)
+	r.first = append(r.first, true)
+}
+
+func (r *jsonRenderer) enterDir(entry treeEntry) {
+	r.open(r.entryNode(entry, "directory"))
+	fmt.Fprint(r.output, `,"children":[`)
+	r.first = append(r.first, true)
+}
+
+func (r *jsonRenderer) leaveDir(entry treeEntry) {
+	r.first = r.first[:len(r.first)-1]
+	fmt.Fprint(r.output, "]}")
+}
+
+func (r *jsonRenderer) file(entry treeEntry) {
+	node := r.entryNode(entry, "file")
+	size := entry.file.Size()
+	node.Size = &size
+	r.open(node)
+	fmt.Fprint(r.output, "}")
+}
+
+func (r *jsonRenderer) omitted(depth int, count int) {
+	r.open(jsonNode{Type: "omitted", Count: count})
+	fmt.Fprint(r.output, "}")
+}
+
+func (r *jsonRenderer) interrupted(depth int) {
+	r.open(jsonNode{Type: "interrupted"})
+	fmt.Fprint(r.output, "}")
+}
+
+func (r *jsonRenderer) end() {
+	r.first = r.first[:len(r.first)-1]
+	fmt.Fprintln(r.output, "]}")
+}
+
+type htmlRenderer struct {
+	output io.Writer
+	base   string
+}
+
+func (r *htmlRenderer) notes(notes []string) string {
+	result := ""
+	for _, note := range notes {
+		result += ` <em>` + html.EscapeString(note) + `</em>`
+	}
+
+	return result
+}
+
+func (r *htmlRenderer) begin(root string) {
+	title := html.EscapeString(getDisplayPath(r.base, root))
+	fmt.Fprintf(r.output, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+}
+
+func (r *htmlRenderer) enterDir(entry treeEntry) {
+	link := "?path=" + url.QueryEscape(getDisplayPath(r.base, entry.path))
+	fmt.Fprintf(r.output, "<li><a href=\"%s\">%s</a>%s\n<ul>\n", html.EscapeString(link), html.EscapeString(entry.name), r.notes(entry.notes))
+}
+
+func (r *htmlRenderer) leaveDir(entry treeEntry) {
+	fmt.Fprint(r.output, "</ul>\n</li>\n")
+}
+
+func (r *htmlRenderer) file(entry treeEntry) {
+	fmt.Fprintf(r.output, "<li>%s (%s)%s</li>\n", html.EscapeString(entry.name), getSize(entry.file), r.notes(entry.notes))
+}
+
+func (r *htmlRenderer) omitted(depth int, count int) {
+	fmt.Fprintf(r.output, "<li><em>%d entries omitted</em></li>\n", count)
+}
+
+func (r *htmlRenderer) interrupted(depth int) {
+	fmt.Fprint(r.output, "<li><em>walk interrupted</em></li>\n")
+}
+
+func (r *htmlRenderer) end() {
+	fmt.Fprint(r.output, "</ul>\n</body>\n</html>\n")
+}
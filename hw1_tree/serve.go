@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of serve, so that a single request can not walk and buffer a
+// whole disk. Every one of them can be lifted with its flag set to 0.
+const (
+	defaultServeDepth   = 10
+	defaultServeTimeout = 10 * time.Second
+	defaultServeMaxSize = 16 << 20
+)
+
+type treeServer struct {
+	root    string
+	opts    treeOptions
+	maxSize int
+}
+
+var errTreeTooLarge = errors.New("[cappedBuffer]: tree is too large")
+
+// cappedBuffer stops the walk once the rendering grows past limit bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit  int
+	cancel context.CancelFunc
+	full   bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.full {
+		return 0, errTreeTooLarge
+	}
+	if b.limit > 0 && b.Len()+len(p) > b.limit {
+		b.full = true
+		b.cancel()
+		return 0, errTreeTooLarge
+	}
+
+	return b.Buffer.Write(p)
+}
+
+var serverFormats = map[string]string{
+	"text/plain":       "text",
+	"application/json": "json",
+	"text/html":        "html",
+}
+
+// getAcceptedFormat picks the supported media type with the highest q value
+// from an Accept header, falling back to plain text.
+func getAcceptedFormat(accept string) (string, string) {
+	bestType, bestQuality := "text/plain", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if mediaType == "*/*" || mediaType == "text/*" {
+			mediaType = "text/plain"
+		}
+		if _, ok := serverFormats[mediaType]; ok && quality > bestQuality {
+			bestType, bestQuality = mediaType, quality
+		}
+	}
+
+	return bestType, serverFormats[bestType]
+}
+
+// getRequestPath resolves the path query parameter inside the server root,
+// refusing anything that leaves it through ".." or symlinks.
+func (s *treeServer) getRequestPath(query string) (string, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(filepath.Clean("/"+query)))
+
+	realRoot, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if realPath != realRoot && !strings.HasPrefix(realPath, realRoot+string(os.PathSeparator)) {
+		return "", fmt.Errorf("[getRequestPath]: path is outside of the root")
+	}
+
+	return path, nil
+}
+
+func (s *treeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	path, err := s.getRequestPath(query.Get("path"))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	// a request may ask for less than the server --depth, never for more
+	opts := s.opts
+	if depth := query.Get("depth"); depth != "" {
+		opts.maxDepth, err = strconv.Atoi(depth)
+		if err != nil || opts.maxDepth < 0 {
+			http.Error(w, "bad depth", http.StatusBadRequest)
+			return
+		}
+		if s.opts.maxDepth > 0 && (opts.maxDepth == 0 || opts.maxDepth > s.opts.maxDepth) {
+			opts.maxDepth = s.opts.maxDepth
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if opts.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	contentType, format := getAcceptedFormat(r.Header.Get("Accept"))
+	output := &cappedBuffer{limit: s.maxSize, cancel: cancel}
+	var renderer treeRenderer
+	switch format {
+	case "json":
+		renderer = &jsonRenderer{output: output, base: s.root}
+	case "html":
+		renderer = &htmlRenderer{output: output, base: s.root}
+	default:
+		renderer = &textRenderer{output: output}
+	}
+
+	err = renderTree(ctx, renderer, path, opts)
+	if output.full {
+		http.Error(w, "tree is too large, ask for a smaller depth", http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil && ctx.Err() == nil {
+		http.Error(w, "failed to read directory", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Vary", "Accept")
+	w.Write(output.Bytes())
+}
+
+func parseServeArgs(args []string) (string, string, treeOptions, int, error) {
+	var opts treeOptions
+	var addr string
+	var maxSize int
+
+	flags := newFlagSet("serve", "usage go run main.go serve [--addr :8080] [--max-size N] . [-f] [-a] ...", &opts)
+	flags.StringVar(&addr, "addr", ":8080", "address to listen on")
+	flags.IntVar(&maxSize, "max-size", defaultServeMaxSize, "refuse trees rendered to more than N bytes, 0 for no limit")
+	flags.Lookup("depth").DefValue = strconv.Itoa(defaultServeDepth)
+	flags.Lookup("timeout").DefValue = defaultServeTimeout.String()
+	opts.maxDepth = defaultServeDepth
+	opts.timeout = defaultServeTimeout
+
+	root, err := parsePath(flags, args)
+	if err != nil {
+		return "", "", opts, 0, err
+	}
+	root, err = filepath.Abs(root)
+
+	return addr, root, opts, maxSize, err
+}
+
+func runServer(addr string, root string, opts treeOptions, maxSize int) error {
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", root, addr)
+
+	// the walk stops at --timeout, the rest is left for sending the tree
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           &treeServer{root: root, opts: opts, maxSize: maxSize},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if opts.timeout > 0 {
+		server.WriteTimeout = opts.timeout + 30*time.Second
+	}
+
+	return server.ListenAndServe()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestTreeServer(t *testing.T) *httptest.Server {
+	root, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(&treeServer{root: root, opts: treeOptions{printFiles: true}})
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getTree(t *testing.T, server *httptest.Server, query string, accept string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", server.URL+"/?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestServeText(t *testing.T) {
+	server := newTestTreeServer(t)
+
+	resp, body := getTree(t, server, "path=zline&depth=1", "")
+	expected := "├───empty.txt (empty)\n└───lorem\n"
+	if resp.StatusCode != http.StatusOK || body != expected {
+		t.Errorf("text Failed - status %d\nGot:\n%v\nExpected:\n%v", resp.StatusCode, body, expected)
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	server := newTestTreeServer(t)
+
+	resp, body := getTree(t, server, "path=zline/lorem", "text/html;q=0.5, application/json")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("json Failed - unexpected content type %s", ct)
+	}
+
+	type node struct {
+		Name     string
+		Path     string
+		Type     string
+		Size     int64
+		Children []node
+	}
+	var tree node
+	if err := json.Unmarshal([]byte(body), &tree); err != nil {
+		t.Fatalf("json Failed - %v\n%s", err, body)
+	}
+	if tree.Path != "zline/lorem" || len(tree.Children) != 3 {
+		t.Fatalf("json Failed - unexpected tree %+v", tree)
+	}
+	ipsum := tree.Children[2]
+	if ipsum.Type != "directory" || ipsum.Path != "zline/lorem/ipsum" || len(ipsum.Children) != 1 || ipsum.Children[0].Size != 70372 {
+		t.Errorf("json Failed - unexpected directory %+v", ipsum)
+	}
+}
+
+func TestServeHTML(t *testing.T) {
+	server := newTestTreeServer(t)
+
+	_, body := getTree(t, server, "path=static&depth=1", "text/html")
+	if !strings.Contains(body, `<li><a href="?path=static%2Fcss">css</a>`) {
+		t.Errorf("html Failed - no link to subdirectory:\n%s", body)
+	}
+	if strings.Contains(body, "body.css") {
+		t.Errorf("html Failed - depth not respected:\n%s", body)
+	}
+}
+
+func TestServeBadRequests(t *testing.T) {
+	server := newTestTreeServer(t)
+
+	if resp, _ := getTree(t, server, "depth=abc", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad depth Failed - got status %d", resp.StatusCode)
+	}
+	if resp, _ := getTree(t, server, "path=missing", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing path Failed - got status %d", resp.StatusCode)
+	}
+	expected := "├───project\n├───static\n├───zline\n└───zzfile.txt (empty)\n"
+	if _, body := getTree(t, server, "path=../..&depth=1", ""); body != expected {
+		t.Errorf("path traversal Failed - results not match\nGot:\n%v\nExpected:\n%v", body, expected)
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	root, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(&treeServer{root: root, opts: treeOptions{printFiles: true, maxDepth: 1}})
+	defer server.Close()
+	expected := "├───project\n├───static\n├───zline\n└───zzfile.txt (empty)\n"
+	for _, query := range []string{"", "depth=0", "depth=5"} {
+		if _, body := getTree(t, server, query, ""); body != expected {
+			t.Errorf("%q: expected the server depth, got\n%v", query, body)
+		}
+	}
+
+	small := httptest.NewServer(&treeServer{root: root, opts: treeOptions{printFiles: true}, maxSize: 64})
+	defer small.Close()
+	if resp, _ := getTree(t, small, "", ""); resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("expected a too large tree to be refused, got status %d", resp.StatusCode)
+	}
+	if resp, _ := getTree(t, small, "path=zline&depth=1", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected a small tree to fit, got status %d", resp.StatusCode)
+	}
+
+	_, _, opts, maxSize, err := parseServeArgs([]string{"."})
+	if err != nil || opts.maxDepth != defaultServeDepth || opts.timeout != defaultServeTimeout || maxSize != defaultServeMaxSize {
+		t.Errorf("unexpected serve defaults %+v, %d, %v", opts, maxSize, err)
+	}
+	_, _, opts, maxSize, err = parseServeArgs([]string{".", "--depth", "0", "--max-size", "0"})
+	if err != nil || opts.maxDepth != 0 || maxSize != 0 {
+		t.Errorf("expected the limits to be lifted, got %+v, %d, %v", opts, maxSize, err)
+	}
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func makeSpecialTree(t *testing.T) string {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, ".hidden"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bin", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(root, "pipe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+const testHiddenResult = `├───.hidden (6b)
+├───bin
+│	└───run.sh (10b)
+├───link (3b)
+└───pipe (fifo)
+`
+
+func TestTreeHidden(t *testing.T) {
+	root := makeSpecialTree(t)
+	out := new(bytes.Buffer)
+	err := dirTree(out, root, true)
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testHiddenResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testHiddenResult)
+	}
+}
+
+const testClassifyResult = `├───bin/
+│	└───run.sh* (10b)
+├───link@ (3b)
+└───pipe| (fifo)
+`
+
+func TestTreeClassify(t *testing.T) {
+	root := makeSpecialTree(t)
+	out := new(bytes.Buffer)
+	err := dirTreeOptions(out, root, treeOptions{printFiles: true, classify: true})
+	if err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testClassifyResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testClassifyResult)
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+
+	return strings.TrimSpace(string(output)), err
+}
+
+func makeRawTerminal() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("[makeRawTerminal]: stdin is not a terminal")
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("[makeRawTerminal]: Error switch terminal to raw mode")
+	}
+
+	return func() { stty(state) }, nil
+}
+
+func getTerminalSize() (rows, cols int) {
+	size, err := stty("size")
+	if err == nil {
+		if _, err := fmt.Sscan(size, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+
+	return 24, 80
+}
+
+// notifyResize reports terminal size changes, so the size is only read
+// again when it changes.
+func notifyResize(resize chan<- os.Signal) func() {
+	signal.Notify(resize, syscall.SIGWINCH)
+	return func() { signal.Stop(resize) }
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func makeRawTerminal() (func(), error) {
+	return nil, fmt.Errorf("[makeRawTerminal]: interactive mode is not supported on windows")
+}
+
+func getTerminalSize() (rows, cols int) {
+	return 24, 80
+}
+
+func notifyResize(resize chan<- os.Signal) func() {
+	return func() {}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type tuiNode struct {
+	entry    treeEntry
+	device   uint64
+	descend  bool
+	parent   *tuiNode
+	children []*tuiNode
+	omitted  int
+	loaded   bool
+	expanded bool
+	err      error
+}
+
+func (n *tuiNode) isDir() bool {
+	return n.entry.file == nil || n.entry.file.IsDir()
+}
+
+type tuiModel struct {
+	walker    *treeWalker
+	root      *tuiNode
+	visible   []*tuiNode
+	cursor    int
+	offset    int
+	searching bool
+	query     string
+	status    string
+
+	// searchCtx interrupts searches that read directories, runTUI cancels
+	// it when a key is pressed during such a search
+	searchCtx context.Context
+}
+
+func newTUIModel(ctx context.Context, path string, opts treeOptions) (*tuiModel, error) {
+	root, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("[newTUIModel]: Error stat root directory")
+	}
+	device, _ := getDevice(root)
+
+	walker := &treeWalker{ctx: ctx, opts: opts}
+	if opts.gitStatus {
+		gitCtx, cancel := walker.withTimeout(ctx)
+		walker.git, err = loadGitStatus(gitCtx, path)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	model := &tuiModel{
+		walker: walker,
+		root:   &tuiNode{entry: treeEntry{path: path, name: path, depth: -1}, device: device, descend: true},
+	}
+	model.load(model.root)
+	model.root.expanded = true
+	model.rebuild()
+
+	return model, nil
+}
+
+// withTimeout limits a single load or search to --timeout, in the TUI it
+// does not apply to the whole session.
+func (w *treeWalker) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if w.opts.timeout > 0 {
+		return context.WithTimeout(ctx, w.opts.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// load reads the children of a directory node once, using the same sorted
+// and filtered entries as getResultTree.
+func (m *tuiModel) load(node *tuiNode) {
+	m.loadContext(m.walker.ctx, node)
+}
+
+// loadContext leaves the node unread when ctx is cancelled, so an
+// interrupted search does not mark directories as failed. A load that runs
+// out of --timeout shows the error on its directory.
+func (m *tuiModel) loadContext(ctx context.Context, node *tuiNode) {
+	if node.loaded || !node.isDir() || !node.descend {
+		return
+	}
+
+	loadCtx, cancel := m.walker.withTimeout(ctx)
+	defer cancel()
+	entries, count, err := getSortedEntriesContext(loadCtx, node.entry.path, m.walker.opts)
+	if err != nil && ctx.Err() != nil && m.walker.ctx.Err() == nil {
+		return
+	}
+	node.loaded = true
+	if err != nil {
+		node.err = err
+		return
+	}
+	defer entries.Close()
+
+	if m.walker.opts.fileLimit > 0 && count > m.walker.opts.fileLimit {
+		node.omitted = count
+		return
+	}
+
+	for indexFile := 0; indexFile < count; indexFile++ {
+		file, err := entries.Next()
+		if err != nil {
+			node.err = err
+			return
+		}
+		entry, device, descend := m.walker.newEntry(node.entry.path, file, node.entry.depth+1, indexFile == count-1, node.device)
+		node.children = append(node.children, &tuiNode{entry: entry, device: device, descend: descend, parent: node})
+	}
+}
+
+func (m *tuiModel) rebuild() {
+	var current *tuiNode
+	if m.cursor < len(m.visible) {
+		current = m.visible[m.cursor]
+	}
+
+	m.visible = m.visible[:0]
+	var walk func(node *tuiNode)
+	walk = func(node *tuiNode) {
+		for _, child := range node.children {
+			m.visible = append(m.visible, child)
+			if child.expanded {
+				walk(child)
+			}
+		}
+	}
+	walk(m.root)
+
+	m.cursor = 0
+	for index, node := range m.visible {
+		if node == current {
+			m.cursor = index
+		}
+	}
+}
+
+func (m *tuiModel) selected() *tuiNode {
+	if m.cursor < len(m.visible) {
+		return m.visible[m.cursor]
+	}
+	return nil
+}
+
+func (m *tuiModel) moveTo(node *tuiNode) {
+	for parent := node.parent; parent != nil; parent = parent.parent {
+		parent.expanded = true
+	}
+	m.rebuild()
+	for index, visible := range m.visible {
+		if visible == node {
+			m.cursor = index
+		}
+	}
+}
+
+func (m *tuiModel) expand() {
+	node := m.selected()
+	if node == nil || !node.isDir() {
+		return
+	}
+	m.load(node)
+	node.expanded = true
+	m.rebuild()
+}
+
+func (m *tuiModel) collapse() {
+	node := m.selected()
+	if node == nil {
+		return
+	}
+	if node.isDir() && node.expanded {
+		node.expanded = false
+		m.rebuild()
+		return
+	}
+	if node.parent != m.root {
+		m.moveTo(node.parent)
+	}
+}
+
+func (m *tuiModel) toggle() {
+	if node := m.selected(); node != nil && node.expanded {
+		m.collapse()
+		return
+	}
+	m.expand()
+}
+
+// next returns the node after node in depth-first order. A deep search loads
+// directories on the way so that it also finds entries in directories that
+// were never opened, otherwise only the loaded ones are visited.
+func (m *tuiModel) next(ctx context.Context, node *tuiNode, deep bool) *tuiNode {
+	if deep {
+		m.loadContext(ctx, node)
+	}
+	if len(node.children) > 0 {
+		return node.children[0]
+	}
+	for ; node != m.root; node = node.parent {
+		siblings := node.parent.children
+		for index, sibling := range siblings {
+			if sibling == node && index+1 < len(siblings) {
+				return siblings[index+1]
+			}
+		}
+	}
+	return nil
+}
+
+func (m *tuiModel) search(fromNext, deep bool) {
+	if m.query == "" {
+		return
+	}
+	ctx := m.searchCtx
+	if ctx == nil {
+		ctx = m.walker.ctx
+	}
+	ctx, cancel := m.walker.withTimeout(ctx)
+	defer cancel()
+	start := m.selected()
+	if start == nil {
+		start = m.root
+	}
+
+	node := start
+	if fromNext || start == m.root {
+		node = m.next(ctx, start, deep)
+	}
+	wrapped := false
+	for {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			m.status = "search timed out"
+			return
+		}
+		if ctx.Err() != nil {
+			m.status = "search interrupted"
+			return
+		}
+		if node == nil {
+			if wrapped {
+				break
+			}
+			wrapped = true
+			node = m.next(ctx, m.root, deep)
+			continue
+		}
+		if strings.Contains(node.entry.file.Name(), m.query) {
+			m.moveTo(node)
+			m.status = ""
+			return
+		}
+		if wrapped && node == start {
+			break
+		}
+		node = m.next(ctx, node, deep)
+	}
+	m.status = fmt.Sprintf("not found: %s", m.query)
+}
+
+func (m *tuiModel) handleKey(key string) (quit bool) {
+	if m.searching {
+		switch key {
+		case "enter":
+			m.searching = false
+			m.search(false, true)
+		case "esc":
+			m.searching = false
+			m.query = ""
+		case "backspace":
+			if len(m.query) > 0 {
+				m.query = m.query[:len(m.query)-1]
+			}
+		default:
+			if len(key) == 1 {
+				m.query += key
+				m.search(false, false)
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(m.visible)-1 {
+			m.cursor++
+		}
+	case "right", "l":
+		m.expand()
+	case "left", "h":
+		m.collapse()
+	case "enter", " ":
+		m.toggle()
+	case "/":
+		m.searching = true
+		m.query = ""
+		m.status = ""
+	case "n":
+		m.search(true, true)
+	}
+	return false
+}
+
+func (m *tuiModel) line(node *tuiNode) string {
+	marker := "  "
+	if node.isDir() {
+		marker = "▸ "
+		if node.expanded {
+			marker = "▾ "
+		}
+	}
+
+	line := strings.Repeat("  ", node.entry.depth) + marker + node.entry.name
+	if !node.isDir() {
+		line += " (" + getSize(node.entry.file) + ")"
+	}
+	line += getNotes(node.entry.notes)
+	if node.expanded && node.omitted > 0 {
+		line += fmt.Sprintf(" [%d entries omitted]", node.omitted)
+	}
+	if node.expanded && node.err != nil {
+		line += " [" + node.err.Error() + "]"
+	}
+
+	return line
+}
+
+func (m *tuiModel) render(output io.Writer, rows, cols int) {
+	height := rows - 1
+	if height < 1 {
+		height = 1
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+
+	fmt.Fprint(output, "\x1b[H\x1b[2J")
+	for index := m.offset; index < len(m.visible) && index < m.offset+height; index++ {
+		line := []rune(m.line(m.visible[index]))
+		if len(line) > cols {
+			line = line[:cols]
+		}
+		if index == m.cursor {
+			fmt.Fprintf(output, "\x1b[7m%s\x1b[0m\r\n", string(line))
+			continue
+		}
+		fmt.Fprintf(output, "%s\r\n", string(line))
+	}
+
+	fmt.Fprintf(output, "\x1b[%d;1H", rows)
+	switch {
+	case m.searching:
+		fmt.Fprint(output, "/"+m.query)
+	case m.status != "":
+		fmt.Fprint(output, m.status)
+	default:
+		fmt.Fprint(output, "↑↓ move  → expand  ← collapse  / search  n next  q quit")
+	}
+}
+
+func readKey(input *bufio.Reader) (string, error) {
+	b, err := input.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch b {
+	case 3:
+		return "ctrl-c", nil
+	case '\r', '\n':
+		return "enter", nil
+	case 127, 8:
+		return "backspace", nil
+	case 27:
+		if input.Buffered() == 0 {
+			return "esc", nil
+		}
+		if next, _ := input.ReadByte(); next != '[' {
+			return "esc", nil
+		}
+		code, err := input.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch code {
+		case 'A':
+			return "up", nil
+		case 'B':
+			return "down", nil
+		case 'C':
+			return "right", nil
+		case 'D':
+			return "left", nil
+		}
+		return "", nil
+	}
+
+	return string(b), nil
+}
+
+type tuiKey struct {
+	key string
+	err error
+}
+
+func readKeys(input *bufio.Reader, keys chan<- tuiKey) {
+	for {
+		key, err := readKey(input)
+		keys <- tuiKey{key, err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// startsDeepSearch tells whether handling key may read the whole tree.
+func (m *tuiModel) startsDeepSearch(key string) bool {
+	if m.searching {
+		return key == "enter"
+	}
+	return key == "n"
+}
+
+// handleKeyInterruptible lets any key pressed during a deep search cancel
+// it. That key is consumed.
+func handleKeyInterruptible(ctx context.Context, model *tuiModel, key string, keys <-chan tuiKey) (bool, error) {
+	if !model.startsDeepSearch(key) {
+		return model.handleKey(key), nil
+	}
+
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	interrupted := make(chan tuiKey, 1)
+	go func() {
+		select {
+		case key := <-keys:
+			cancel()
+			interrupted <- key
+		case <-done:
+		}
+		close(interrupted)
+	}()
+
+	model.searchCtx = searchCtx
+	quit := model.handleKey(key)
+	model.searchCtx = nil
+	close(done)
+
+	if key, ok := <-interrupted; ok && key.err != nil {
+		return quit, key.err
+	}
+	return quit, nil
+}
+
+func runTUI(ctx context.Context, path string, opts treeOptions) error {
+	model, err := newTUIModel(ctx, path, opts)
+	if err != nil {
+		return err
+	}
+
+	restore, err := makeRawTerminal()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	output := bufio.NewWriter(os.Stdout)
+	defer fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J\x1b[?25h")
+	fmt.Fprint(output, "\x1b[?25l")
+
+	keys := make(chan tuiKey)
+	go readKeys(bufio.NewReader(os.Stdin), keys)
+
+	resize := make(chan os.Signal, 1)
+	stopResize := notifyResize(resize)
+	defer stopResize()
+
+	rows, cols := getTerminalSize()
+	for {
+		model.render(output, rows, cols)
+		output.Flush()
+
+		select {
+		case <-resize:
+			rows, cols = getTerminalSize()
+		case key := <-keys:
+			if key.err != nil {
+				return key.err
+			}
+			quit, err := handleKeyInterruptible(ctx, model, key.key, keys)
+			if quit || err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getVisibleNames(model *tuiModel) string {
+	var names []string
+	for _, node := range model.visible {
+		names = append(names, strings.Repeat(" ", node.entry.depth)+node.entry.name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestTUINavigation(t *testing.T) {
+	model, err := newTUIModel(context.Background(), "testdata", treeOptions{printFiles: true})
+	if err != nil {
+		t.Fatalf("newTUIModel failed: %v", err)
+	}
+
+	if names := getVisibleNames(model); names != "project,static,zline,zzfile.txt" {
+		t.Errorf("unexpected initial tree: %s", names)
+	}
+
+	for _, key := range []string{"right", "down", "down", "down"} {
+		model.handleKey(key)
+	}
+	if names := getVisibleNames(model); names != "project, file.txt, gopher.png,static,zline,zzfile.txt" {
+		t.Errorf("unexpected expanded tree: %s", names)
+	}
+	if node := model.selected(); node.entry.name != "static" {
+		t.Errorf("expected cursor on static, got %s", node.entry.name)
+	}
+
+	model.cursor = 1
+	model.handleKey("left")
+	if node := model.selected(); node.entry.name != "project" {
+		t.Errorf("expected cursor to move to parent, got %s", node.entry.name)
+	}
+	model.handleKey("left")
+	if names := getVisibleNames(model); names != "project,static,zline,zzfile.txt" {
+		t.Errorf("unexpected collapsed tree: %s", names)
+	}
+
+	if quit := model.handleKey("q"); !quit {
+		t.Errorf("expected q to quit")
+	}
+}
+
+func TestTUISearch(t *testing.T) {
+	model, err := newTUIModel(context.Background(), "testdata", treeOptions{printFiles: true})
+	if err != nil {
+		t.Fatalf("newTUIModel failed: %v", err)
+	}
+
+	for _, key := range []string{"/", "s", "i", "t", "e", "enter"} {
+		model.handleKey(key)
+	}
+	node := model.selected()
+	if node.entry.path != "testdata/static/js/site.js" {
+		t.Errorf("search Failed - got %s", node.entry.path)
+	}
+
+	model.query = "gopher"
+	model.handleKey("n")
+	model.handleKey("n")
+	node = model.selected()
+	if node.entry.path != "testdata/static/z_lorem/ipsum/gopher.png" {
+		t.Errorf("search next Failed - got %s", node.entry.path)
+	}
+
+	out := new(bytes.Buffer)
+	model.render(out, 10, 80)
+	if !strings.Contains(out.String(), "\x1b[7m        gopher.png (70372b)\x1b[0m") {
+		t.Errorf("render Failed - selected line not highlighted:\n%q", out.String())
+	}
+}
+
+func TestTUISearchLoadedOnly(t *testing.T) {
+	model, err := newTUIModel(context.Background(), "testdata", treeOptions{printFiles: true})
+	if err != nil {
+		t.Fatalf("newTUIModel failed: %v", err)
+	}
+
+	// typing only looks through directories that are already read
+	for _, key := range []string{"/", "s", "i", "t", "e"} {
+		model.handleKey(key)
+	}
+	if model.status != "not found: site" {
+		t.Errorf("expected incremental search to skip unread directories, got %q", model.status)
+	}
+	for _, node := range model.root.children {
+		if node.loaded {
+			t.Errorf("expected %s to stay unread", node.entry.name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	model.searchCtx = ctx
+	model.handleKey("enter")
+	if model.status != "search interrupted" {
+		t.Errorf("expected interrupted search, got %q", model.status)
+	}
+	for _, node := range model.root.children {
+		if node.loaded || node.err != nil {
+			t.Errorf("expected interrupted search to leave %s unread", node.entry.name)
+		}
+	}
+
+	model.searchCtx = nil
+	model.searching = true
+	model.handleKey("enter")
+	if node := model.selected(); node.entry.path != "testdata/static/js/site.js" {
+		t.Errorf("search Failed - got %s", node.entry.path)
+	}
+}
+
+func TestTUITimeoutPerLoad(t *testing.T) {
+	model, err := newTUIModel(context.Background(), "testdata", treeOptions{printFiles: true, timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newTUIModel failed: %v", err)
+	}
+
+	// the session outlives --timeout, only a single load is limited by it
+	time.Sleep(50 * time.Millisecond)
+	model.handleKey("right")
+	if node := model.selected(); node.err != nil {
+		t.Errorf("unexpected error after --timeout: %v", node.err)
+	}
+	if names := getVisibleNames(model); names != "project, file.txt, gopher.png,static,zline,zzfile.txt" {
+		t.Errorf("unexpected expanded tree: %s", names)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"runtime/debug"
+	"sync"
+)
+
+// CacheStats counts lookups of a SignerCache. Shared are the calls that
+// waited for an identical call already in flight instead of signing again.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Shared    uint64
+	Evictions uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value string
+}
+
+type cacheCall struct {
+	done  chan struct{}
+	value string
+	err   error
+	// cancelled is set when the ctx of the caller that ran the call was
+	// done, its result is not meant for the other callers
+	cancelled bool
+}
+
+// SignerCache memoizes the results of one signer, keeping at most size of
+// them and evicting the least recently used. Failed calls are not cached.
+// Results are kept per DataSignerSalt, so changing the salt does not
+// return hashes made with the old one.
+type SignerCache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List
+	inFlight map[string]*cacheCall
+	stats    CacheStats
+}
+
+func NewSignerCache(size int) *SignerCache {
+	return &SignerCache{
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inFlight: make(map[string]*cacheCall),
+	}
+}
+
+func (c *SignerCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *SignerCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *SignerCache) store(key, value string) {
+	if c.size < 1 {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *SignerCache) Wrap(signer SignerFunc) SignerFunc {
+	return c.wrap("", signer)
+}
+
+// wrap keeps the results of signer apart from the other signers sharing
+// the cache under the same namespace prefix.
+func (c *SignerCache) wrap(namespace string, signer SignerFunc) SignerFunc {
+	return func(ctx context.Context, data string) (string, error) {
+		key := namespace + "\x00" + DataSignerSalt + "\x00" + data
+
+		for {
+			c.mu.Lock()
+			if element, ok := c.entries[key]; ok {
+				c.order.MoveToFront(element)
+				c.stats.Hits++
+				c.mu.Unlock()
+				return element.Value.(*cacheEntry).value, nil
+			}
+			call, ok := c.inFlight[key]
+			if !ok {
+				call = &cacheCall{done: make(chan struct{})}
+				c.inFlight[key] = call
+				c.stats.Misses++
+				c.mu.Unlock()
+
+				c.do(ctx, key, call, signer, data)
+				return call.value, call.err
+			}
+			c.stats.Shared++
+			c.mu.Unlock()
+
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			// a call cancelled by its own caller is tried again, the waiter
+			// may run it this time
+			if !call.cancelled {
+				return call.value, call.err
+			}
+		}
+	}
+}
+
+// do runs signer for call and releases the callers waiting for it even
+// when signer panics; they get the panic as a PanicError and the panic
+// goes on in the calling goroutine.
+func (c *SignerCache) do(ctx context.Context, key string, call *cacheCall, signer SignerFunc, data string) {
+	defer func() {
+		value := recover()
+		if value != nil {
+			call.err = &PanicError{Value: value, Stack: debug.Stack()}
+		}
+
+		call.cancelled = call.err != nil && ctx.Err() != nil
+
+		c.mu.Lock()
+		delete(c.inFlight, key)
+		if call.err == nil {
+			c.store(key, call.value)
+		}
+		c.mu.Unlock()
+		close(call.done)
+
+		if value != nil {
+			panic(value)
+		}
+	}()
+
+	call.value, call.err = signer(ctx, data)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignerCacheLRU(t *testing.T) {
+	var calls int32
+	cache := NewSignerCache(2)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return data + "!", nil
+	})
+
+	for _, data := range []string{"a", "b", "a", "c", "b"} {
+		result, err := signer(context.Background(), data)
+		if err != nil || result != data+"!" {
+			t.Fatalf("unexpected result %q, %v", result, err)
+		}
+	}
+
+	// "b" was evicted by "c" because "a" had been used more recently
+	expected := CacheStats{Hits: 1, Misses: 4, Evictions: 2}
+	if stats := cache.Stats(); stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 calls, got %d", calls)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.Len())
+	}
+}
+
+func TestSignerCacheSingleflight(t *testing.T) {
+	var calls int32
+	cache := NewSignerCache(10)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return data, nil
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, err := signer(context.Background(), "data"); err != nil || result != "data" {
+				t.Errorf("unexpected result %q, %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits+stats.Shared != 9 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSignerCacheLeaderCancel(t *testing.T) {
+	var calls int32
+	started := make(chan struct{}, 2)
+	cache := NewSignerCache(10)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		select {
+		case <-time.After(20 * time.Millisecond):
+			return data, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := signer(ctx, "data")
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan string, 1)
+	go func() {
+		result, err := signer(context.Background(), "data")
+		if err != nil {
+			t.Errorf("the waiter should not get the leader's error, got %v", err)
+		}
+		waiter <- result
+	}()
+	for cache.Stats().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if err := <-leader; err != context.Canceled {
+		t.Errorf("expected the leader to be cancelled, got %v", err)
+	}
+	if result := <-waiter; result != "data" {
+		t.Errorf("unexpected result %q", result)
+	}
+	if calls != 2 {
+		t.Errorf("expected the waiter to sign again, calls: %d", calls)
+	}
+}
+
+func TestSignerCacheError(t *testing.T) {
+	var calls int32
+	cache := NewSignerCache(10)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return "", errors.New("signer failed")
+		}
+		return data, nil
+	})
+
+	if _, err := signer(context.Background(), "data"); err == nil {
+		t.Fatal("expected error")
+	}
+	if result, err := signer(context.Background(), "data"); err != nil || result != "data" {
+		t.Errorf("unexpected result %q, %v", result, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestSignerCachePanic(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	cache := NewSignerCache(10)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+			panic("signer failed")
+		}
+		return data, nil
+	})
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		signer(context.Background(), "data")
+	}()
+	for cache.Stats().Misses != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shared := make(chan error)
+	go func() {
+		_, err := signer(context.Background(), "data")
+		shared <- err
+	}()
+	for cache.Stats().Shared != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if value := <-panicked; value != "signer failed" {
+		t.Errorf("expected the panic to go on, got %v", value)
+	}
+	var panicErr *PanicError
+	if err := <-shared; !errors.As(err, &panicErr) {
+		t.Errorf("expected PanicError for the waiting call, got %v", err)
+	}
+	if result, err := signer(context.Background(), "data"); err != nil || result != "data" {
+		t.Errorf("unexpected result %q, %v", result, err)
+	}
+}
+
+func TestSignerCacheSalt(t *testing.T) {
+	defer func(salt string) { DataSignerSalt = salt }(DataSignerSalt)
+
+	var calls int32
+	cache := NewSignerCache(10)
+	signer := cache.Wrap(func(ctx context.Context, data string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return data + DataSignerSalt, nil
+	})
+
+	for _, salt := range []string{"", "salt", ""} {
+		DataSignerSalt = salt
+		if result, _ := signer(context.Background(), "data"); result != "data"+salt {
+			t.Errorf("salt %q: unexpected result %q", salt, result)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestPoolCache(t *testing.T) {
+	useFastSigners(t)
+
+	var calls int32
+	fastCrc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddInt32(&calls, 1)
+		return fastCrc32(data)
+	}
+
+	cfg := PoolConfig{
+		Workers:    4,
+		Md5Cache:   NewSignerCache(100),
+		Crc32Cache: NewSignerCache(100),
+	}
+	inputs := []int{0, 1, 1, 0, 1}
+	results, err := RunStage(context.Background(), inputs, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if combined := combineResults(results); combined != testHash0+"_"+testHash0+"_"+testHash1+"_"+testHash1+"_"+testHash1 {
+		t.Errorf("unexpected result %v", combined)
+	}
+	// 2 distinct inputs: crc32(data) and crc32(md5(data)) for each,
+	// plus 6 crc32 calls per distinct SingleHash result
+	if calls != 2*2+2*6 {
+		t.Errorf("expected %d crc32 calls, got %d", 2*2+2*6, calls)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type cliOptions struct {
+	salt        string
+	workers     int
+	hashWorkers int
+	lines       bool
+	stream      bool
+	recipe      string
+	input       string
+}
+
+func parseArgs(args []string) (cliOptions, error) {
+	var opts cliOptions
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage go run . [--salt S] [--workers N] [--hash-workers N] [--lines] [--stream] [--recipe recipe.json] [file]")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.salt, "salt", "", "salt appended to the data before hashing (DataSignerSalt)")
+	flags.IntVar(&opts.workers, "workers", 16, "items signed at the same time")
+	flags.IntVar(&opts.hashWorkers, "hash-workers", 0, "concurrent crc32 calls per stage, 0 for no limit")
+	flags.BoolVar(&opts.lines, "lines", false, "sign every line as is instead of reading integers")
+	flags.BoolVar(&opts.stream, "stream", false, "print every item as soon as it is signed")
+	flags.StringVar(&opts.recipe, "recipe", "", "JSON signing recipe")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return opts, errors.New("too many arguments")
+	}
+	opts.input = flags.Arg(0)
+	return opts, nil
+}
+
+// maxLineSize bounds the lines read with --lines.
+const maxLineSize = 1 << 20
+
+// scanInputs calls send with one item per line. Integers are normalized the
+// same way SingleHash formats its int inputs, blank lines between them are
+// skipped.
+func scanInputs(input io.Reader, lines bool, send func(input string) error) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, maxLineSize)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if !lines {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			value, err := strconv.Atoi(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %q is not an integer", number, line)
+			}
+			line = strconv.Itoa(value)
+		}
+		if err := send(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func runSigner(ctx context.Context, input io.Reader, output io.Writer, opts cliOptions) error {
+	cfg := PoolConfig{Workers: opts.workers, HashWorkers: opts.hashWorkers, Ordered: opts.stream}
+	if opts.recipe != "" {
+		file, err := os.Open(opts.recipe)
+		if err != nil {
+			return err
+		}
+		recipe, err := LoadRecipe(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		cfg.Recipe = &recipe
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the input is read in its own goroutine and signed while it is read; a
+	// read from a terminal can not be interrupted, so it is left behind when
+	// the stage stops first
+	var mu sync.Mutex
+	var pending []string
+	inputs := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(inputs)
+		scanErr <- scanInputs(input, opts.lines, func(input string) error {
+			if opts.stream {
+				mu.Lock()
+				pending = append(pending, input)
+				mu.Unlock()
+			}
+			return sendTo(ctx, inputs, input)
+		})
+	}()
+
+	var results []string
+	err := FeedStage(ctx, inputs, Chain(SingleHashStringPool(cfg), MultiHashPool(cfg)), func(result string) error {
+		if opts.stream {
+			// ordered stages emit results in the order of inputs
+			mu.Lock()
+			input := pending[0]
+			pending = pending[1:]
+			mu.Unlock()
+			if _, err := fmt.Fprintf(output, "%s\t%s\n", input, result); err != nil {
+				return err
+			}
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := <-scanErr; err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(output, combineResults(results))
+	return err
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	DataSignerSalt = opts.salt
+
+	input := os.Stdin
+	if opts.input != "" && opts.input != "-" {
+		input, err = os.Open(opts.input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "signer:", err)
+			os.Exit(1)
+		}
+		defer input.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := runSigner(ctx, input, os.Stdout, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "signer:", err)
+		stop()
+		os.Exit(1)
+	}
+}
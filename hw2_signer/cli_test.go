@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func scanAll(input string, lines bool) ([]string, error) {
+	var inputs []string
+	err := scanInputs(strings.NewReader(input), lines, func(input string) error {
+		inputs = append(inputs, input)
+		return nil
+	})
+	return inputs, err
+}
+
+func TestScanInputs(t *testing.T) {
+	inputs, err := scanAll("1\n\n +02 \r\n-3\n", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(inputs, ",") != "1,2,-3" {
+		t.Errorf("unexpected inputs %q", inputs)
+	}
+
+	if _, err := scanAll("1\nabc\n", false); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+
+	inputs, err = scanAll("abc\n\n 1\n", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(inputs, ",") != "abc,, 1" {
+		t.Errorf("unexpected inputs %q", inputs)
+	}
+
+	long := strings.Repeat("x", 100*1024)
+	inputs, err = scanAll(long+"\n", true)
+	if err != nil || len(inputs) != 1 || inputs[0] != long {
+		t.Errorf("expected one long line, got %d inputs, %v", len(inputs), err)
+	}
+}
+
+func TestRunSigner(t *testing.T) {
+	useFastSigners(t)
+
+	output := &bytes.Buffer{}
+	opts, err := parseArgs([]string{"--workers", "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := runSigner(context.Background(), strings.NewReader("1\n0\n"), output, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := testHash0 + "_" + testHash1 + "\n"; output.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", output.String(), expected)
+	}
+}
+
+func TestRunSignerStream(t *testing.T) {
+	useFastSigners(t)
+
+	output := &bytes.Buffer{}
+	opts, err := parseArgs([]string{"--stream", "--workers", "4"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := runSigner(context.Background(), strings.NewReader("1\n0\n1\n"), output, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "1\t" + testHash1 + "\n" +
+		"0\t" + testHash0 + "\n" +
+		"1\t" + testHash1 + "\n" +
+		testHash0 + "_" + testHash1 + "_" + testHash1 + "\n"
+	if output.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", output.String(), expected)
+	}
+}
+
+func TestRunSignerStreamBeforeEOF(t *testing.T) {
+	useFastSigners(t)
+
+	opts, err := parseArgs([]string{"--stream"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	input, writeInput := io.Pipe()
+	readOutput, output := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- runSigner(context.Background(), input, output, opts)
+		output.Close()
+	}()
+
+	if _, err := io.WriteString(writeInput, "0\n"); err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(readOutput)
+	if !lines.Scan() || lines.Text() != "0\t"+testHash0 {
+		t.Fatalf("expected the first result before the end of input, got %q", lines.Text())
+	}
+
+	writeInput.Close()
+	if !lines.Scan() || lines.Text() != testHash0 {
+		t.Errorf("unexpected combined result %q", lines.Text())
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunSignerBadInput(t *testing.T) {
+	useFastSigners(t)
+
+	err := runSigner(context.Background(), strings.NewReader("1\nabc\n"), io.Discard, cliOptions{workers: 1})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestRunSignerRecipe(t *testing.T) {
+	useFastSigners(t)
+
+	path := filepath.Join(t.TempDir(), "recipe.json")
+	if err := os.WriteFile(path, []byte(`{"rounds": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"--lines", "--recipe", path, "input.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.input != "input.txt" {
+		t.Errorf("expected input file, got %q", opts.input)
+	}
+
+	output := &bytes.Buffer{}
+	if err := runSigner(context.Background(), strings.NewReader("0\n"), output, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the first round of the default recipe is the first part of testHash0
+	if result := strings.TrimSpace(output.String()); !strings.HasPrefix(testHash0, result) || result == testHash0 {
+		t.Errorf("unexpected result %q", result)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// DeadLetter is an item a stage gave up on instead of failing: a malformed
+// input or, with PoolConfig.DeadLetter, an item the stage panicked on.
+type DeadLetter struct {
+	Stage string
+	Item  interface{}
+	Err   error
+}
+
+type DeadLetterSink func(letter DeadLetter)
+
+// DeadLetterChannel sends the letters to ch, blocking the stage while ch
+// is full. Once ctx is done letters that do not fit into ch go to the
+// default sink instead.
+func DeadLetterChannel(ctx context.Context, ch chan<- DeadLetter) DeadLetterSink {
+	return func(letter DeadLetter) {
+		select {
+		case ch <- letter:
+			return
+		default:
+		}
+		select {
+		case ch <- letter:
+		case <-ctx.Done():
+			logDeadLetter(letter)
+		}
+	}
+}
+
+// logDeadLetter is the default sink. It logs through the logger set with
+// SetLogger or slog.Default, so dead letters are seen even when the hash
+// stages are quiet.
+func logDeadLetter(letter DeadLetter) {
+	logger := currentLogger.Load()
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Error("dead letter", "stage", letter.Stage, "item", letter.Item, "error", letter.Err)
+}
+
+var currentDeadLetterSink atomic.Pointer[DeadLetterSink]
+
+// SetDeadLetterSink sets where SingleHash, MultiHash and CombineResults send
+// malformed items; nil restores the default, which logs them at error level
+// even when no logger is set.
+func SetDeadLetterSink(sink DeadLetterSink) {
+	if sink == nil {
+		currentDeadLetterSink.Store(nil)
+		return
+	}
+	currentDeadLetterSink.Store(&sink)
+}
+
+func getDeadLetterSink() DeadLetterSink {
+	if sink := currentDeadLetterSink.Load(); sink != nil {
+		return *sink
+	}
+	return logDeadLetter
+}
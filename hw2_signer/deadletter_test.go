@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDeadLetterChannel(t *testing.T) {
+	useFastSigners(t)
+
+	letters := make(chan DeadLetter, 10)
+	sink := DeadLetterChannel(context.Background(), letters)
+	var result interface{}
+	ExecutePipeline(
+		func(in, out chan interface{}) {
+			out <- 0
+			out <- "malformed"
+			out <- 1
+		},
+		SingleHashDeadLetters(sink),
+		func(in, out chan interface{}) {
+			for value := range in {
+				out <- value
+			}
+			out <- 2.5
+		},
+		MultiHashDeadLetters(sink),
+		CombineResultsDeadLetters(sink),
+		func(in, out chan interface{}) {
+			result = <-in
+		},
+	)
+	close(letters)
+
+	if expected := testHash0 + "_" + testHash1; result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	var got []DeadLetter
+	for letter := range letters {
+		got = append(got, letter)
+	}
+	if len(got) != 2 || got[0].Item != "malformed" || got[1].Item != 2.5 {
+		t.Fatalf("unexpected dead letters %+v", got)
+	}
+	if got[0].Stage != "SingleHash" || got[0].Err.Error() != "SingleHash: expected int, got string" {
+		t.Errorf("unexpected dead letter %+v", got[0])
+	}
+	if got[1].Stage != "MultiHash" || got[1].Err.Error() != "MultiHash: expected string, got float64" {
+		t.Errorf("unexpected dead letter %+v", got[1])
+	}
+}
+
+func TestDefaultDeadLetterSink(t *testing.T) {
+	useFastSigners(t)
+
+	var letters []DeadLetter
+	SetDeadLetterSink(func(letter DeadLetter) {
+		letters = append(letters, letter)
+	})
+	t.Cleanup(func() {
+		SetDeadLetterSink(nil)
+	})
+
+	var result interface{}
+	ExecutePipeline(
+		func(in, out chan interface{}) {
+			out <- 1
+			out <- 0
+		},
+		SingleHash,
+		MultiHash,
+		func(in, out chan interface{}) {
+			out <- 3
+			for value := range in {
+				out <- value
+			}
+		},
+		CombineResults,
+		func(in, out chan interface{}) {
+			result = <-in
+		},
+	)
+
+	if len(letters) != 1 || letters[0].Item != 3 || letters[0].Stage != "CombineResults" {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+	if expected := testHash0 + "_" + testHash1; result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestStageJobDeadLetters(t *testing.T) {
+	useFastSigners(t)
+
+	mu := &sync.Mutex{}
+	var letters []DeadLetter
+	sink := func(letter DeadLetter) {
+		mu.Lock()
+		defer mu.Unlock()
+		letters = append(letters, letter)
+	}
+
+	var result interface{}
+	err := ExecutePipelineContext(context.Background(),
+		sourceJob(1, "1", 0, nil),
+		StageJobDeadLetters("SingleHash", SingleHashStage, sink),
+		MultiHashContext,
+		CombineResultsContext,
+		func(ctx context.Context, in, out chan interface{}) error {
+			result = <-in
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := testHash0 + "_" + testHash1; result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if len(letters) != 2 || letters[0].Item != "1" || letters[1].Item != nil {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+}
+
+func TestDeadLetterChannelDone(t *testing.T) {
+	buf := new(bytes.Buffer)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	letters := make(chan DeadLetter, 1)
+	sink := DeadLetterChannel(ctx, letters)
+
+	sink(DeadLetter{Stage: "SingleHash", Item: "first"})
+	sink(DeadLetter{Stage: "SingleHash", Item: "second"})
+
+	if letter := <-letters; letter.Item != "first" {
+		t.Errorf("expected the first letter in the channel, got %+v", letter)
+	}
+	if !strings.Contains(buf.String(), "item=second") {
+		t.Errorf("expected the second letter in the default log, got %q", buf.String())
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// Graph is a DAG of jobs. The output of a job goes to every job connected
+// after it, or to one of them when the job is partitioned by key; a job
+// connected after several others reads all of their outputs merged. As in
+// ExecutePipelineContext, the input of a job is closed once all of its
+// producers have returned, and the first error cancels the whole graph.
+type Graph struct {
+	nodes []*graphNode
+	names map[string]*graphNode
+	err   error
+}
+
+type graphNode struct {
+	name      string
+	job       contextJob
+	consumers []*graphNode
+	producers int
+	key       func(value interface{}) string
+
+	in      chan interface{}
+	pending int
+	mu      sync.Mutex
+}
+
+func NewGraph() *Graph {
+	return &Graph{names: make(map[string]*graphNode)}
+}
+
+func (g *Graph) fail(err error) *Graph {
+	if g.err == nil {
+		g.err = err
+	}
+	return g
+}
+
+func (g *Graph) node(name string) *graphNode {
+	node, ok := g.names[name]
+	if !ok {
+		g.fail(fmt.Errorf("graph: unknown job %q", name))
+	}
+	return node
+}
+
+// Add adds a job under a unique name.
+func (g *Graph) Add(name string, currentJob contextJob) *Graph {
+	if _, ok := g.names[name]; ok {
+		return g.fail(fmt.Errorf("graph: duplicate job %q", name))
+	}
+	node := &graphNode{name: name, job: currentJob}
+	g.nodes = append(g.nodes, node)
+	g.names[name] = node
+	return g
+}
+
+// Connect sends the output of the from job to the to job.
+func (g *Graph) Connect(from, to string) *Graph {
+	producer, consumer := g.node(from), g.node(to)
+	if producer == nil || consumer == nil {
+		return g
+	}
+	producer.consumers = append(producer.consumers, consumer)
+	consumer.producers++
+	return g
+}
+
+// Partition sends every output of the job to a single consumer picked by
+// the hash of key(value), so equal keys always go to the same consumer.
+func (g *Graph) Partition(name string, key func(value interface{}) string) *Graph {
+	if node := g.node(name); node != nil {
+		node.key = key
+	}
+	return g
+}
+
+func (g *Graph) checkCycles() error {
+	producers := make(map[*graphNode]int, len(g.nodes))
+	var ready []*graphNode
+	for _, node := range g.nodes {
+		producers[node] = node.producers
+		if node.producers == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	visited := 0
+	for len(ready) > 0 {
+		node := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, consumer := range node.consumers {
+			producers[consumer]--
+			if producers[consumer] == 0 {
+				ready = append(ready, consumer)
+			}
+		}
+	}
+
+	if visited != len(g.nodes) {
+		return fmt.Errorf("graph: jobs form a cycle")
+	}
+	return nil
+}
+
+// producerDone closes the input of the node after its last producer.
+func (node *graphNode) producerDone() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.pending--
+	if node.pending == 0 {
+		close(node.in)
+	}
+}
+
+func (node *graphNode) route(ctx context.Context, out chan interface{}) error {
+	defer func() {
+		for _, consumer := range node.consumers {
+			consumer.producerDone()
+		}
+	}()
+
+	for output := range out {
+		consumers := node.consumers
+		if node.key != nil && len(consumers) > 0 {
+			hash := fnv.New32a()
+			hash.Write([]byte(node.key(output)))
+			index := hash.Sum32() % uint32(len(consumers))
+			consumers = consumers[index : index+1]
+		}
+		for _, consumer := range consumers {
+			if err := send(ctx, consumer.in, output); err != nil {
+				go drain(out)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run starts every job and waits for all of them to return. A graph can
+// be run only once.
+func (g *Graph) Run(ctx context.Context) error {
+	if g.err != nil {
+		return g.err
+	}
+	if err := g.checkCycles(); err != nil {
+		return err
+	}
+
+	group, ctx := newErrGroup(ctx)
+	for _, node := range g.nodes {
+		node.in = make(chan interface{}, 1)
+		node.pending = node.producers
+		if node.producers == 0 {
+			close(node.in)
+		}
+	}
+
+	for _, node := range g.nodes {
+		out := make(chan interface{}, 1)
+		group.Go(func(node *graphNode) func() error {
+			return func() error {
+				defer close(out)
+				err := node.job(ctx, node.in, out)
+				go drain(node.in)
+				return err
+			}
+		}(node))
+		group.Go(func(node *graphNode) func() error {
+			return func() error {
+				return node.route(ctx, out)
+			}
+		}(node))
+	}
+
+	return group.Wait()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func sourceJob(values ...interface{}) contextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for _, value := range values {
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+type collector struct {
+	mu     sync.Mutex
+	values []string
+}
+
+func (c *collector) job(ctx context.Context, in, out chan interface{}) error {
+	for value := range in {
+		c.mu.Lock()
+		c.values = append(c.values, value.(string))
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *collector) sorted() []string {
+	sort.Strings(c.values)
+	return c.values
+}
+
+func TestGraphBroadcastMerge(t *testing.T) {
+	useFastSigners(t)
+
+	var plain, upper collector
+	err := NewGraph().
+		Add("source", sourceJob(0, 1)).
+		Add("single", SingleHashContext).
+		Add("multi", MultiHashContext).
+		Add("plain", plain.job).
+		Add("upper", StageJob("upper", Stage[string, string](func(ctx context.Context, in <-chan string, out chan<- string) error {
+			for value := range in {
+				if err := sendTo(ctx, out, strings.ToUpper(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))).
+		Add("merged", upper.job).
+		Connect("source", "single").
+		Connect("single", "multi").
+		Connect("multi", "plain").
+		Connect("single", "upper").
+		Connect("upper", "merged").
+		Connect("multi", "merged").
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := []string{testHash0, testHash1}; !reflect.DeepEqual(plain.sorted(), expected) {
+		t.Errorf("expected %v, got %v", expected, plain.values)
+	}
+	if len(upper.values) != 4 {
+		t.Errorf("expected 2 single and 2 multi hashes merged, got %v", upper.values)
+	}
+}
+
+func TestGraphPartition(t *testing.T) {
+	var even, odd collector
+	key := func(value interface{}) string { return value.(string)[:1] }
+	err := NewGraph().
+		Add("source", sourceJob("a1", "b1", "a2", "b2", "a3")).
+		Add("first", even.job).
+		Add("second", odd.job).
+		Connect("source", "first").
+		Connect("source", "second").
+		Partition("source", key).
+		Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(even.values)+len(odd.values) != 5 {
+		t.Fatalf("expected every value once, got %v and %v", even.values, odd.values)
+	}
+	for _, values := range [][]string{even.values, odd.values} {
+		for _, value := range values {
+			if key(value) != key(values[0]) {
+				t.Errorf("expected equal keys in one partition, got %v", values)
+			}
+		}
+	}
+}
+
+func TestGraphErrors(t *testing.T) {
+	failure := errors.New("job failed")
+	var sink collector
+	err := NewGraph().
+		Add("source", sourceJob("a", "b", "c")).
+		Add("failing", func(ctx context.Context, in, out chan interface{}) error {
+			<-in
+			return failure
+		}).
+		Add("sink", sink.job).
+		Connect("source", "failing").
+		Connect("source", "sink").
+		Run(context.Background())
+	if err != failure {
+		t.Errorf("expected %v, got %v", failure, err)
+	}
+
+	cases := map[string]*Graph{
+		"duplicate": NewGraph().Add("a", sink.job).Add("a", sink.job),
+		"unknown":   NewGraph().Add("a", sink.job).Connect("a", "b"),
+		"cycle":     NewGraph().Add("a", sink.job).Add("b", sink.job).Connect("a", "b").Connect("b", "a"),
+	}
+	for name, graph := range cases {
+		if err := graph.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "graph:") {
+			t.Errorf("%s: expected graph error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrPipelineStopped = errors.New("pipeline is stopped")
+	ErrPipelineStarted = errors.New("pipeline is already started")
+)
+
+// Tracked is an item on its way through a Pipeline. Value is the result of
+// the stages so far and the id names the submitted input it comes from.
+type Tracked[T any] struct {
+	Value T
+	id    uint64
+	owner itemTracker
+}
+
+// itemTracker is the Pipeline a Tracked item was submitted to.
+type itemTracker interface {
+	// forget drops the input of id from the inputs in flight and returns it.
+	forget(id uint64) (any, bool)
+}
+
+// Track runs fn over tracked items on a pool like the hash pools, so
+// tracked stages can be joined with Chain into the stage of a Pipeline.
+// Dead letters get the input submitted to the pipeline.
+func Track[In, Out any](name string, cfg PoolConfig, fn func(ctx context.Context, input In) (Out, error)) Stage[Tracked[In], Tracked[Out]] {
+	return trackedPoolStage(name, cfg, func(ctx context.Context, item uint64, input In) (Out, error) {
+		return fn(ctx, input)
+	})
+}
+
+func trackedPoolStage[In, Out any](name string, cfg PoolConfig, fn func(ctx context.Context, item uint64, input In) (Out, error)) Stage[Tracked[In], Tracked[Out]] {
+	if sink := cfg.DeadLetter; sink != nil {
+		cfg.DeadLetter = func(letter DeadLetter) {
+			item := letter.Item.(Tracked[In])
+			letter.Item = item.Value
+			if item.owner != nil {
+				if input, ok := item.owner.forget(item.id); ok {
+					letter.Item = input
+				}
+			}
+			sink(letter)
+		}
+	}
+	return countedPoolStage(name, cfg, func(ctx context.Context, n uint64, item Tracked[In]) (Tracked[Out], error) {
+		output, err := fn(ctx, n, item.Value)
+		return Tracked[Out]{Value: output, id: item.id, owner: item.owner}, err
+	})
+}
+
+// Pipeline runs a stage over inputs submitted while it is running. Every
+// output is handed to handle together with its input, and the inputs that
+// have not come out of the stage yet are known when the pipeline is
+// stopped. The stage is built from Track and the tracked hash pools, one
+// output per input at most.
+type Pipeline[In, Out any] struct {
+	stage  Stage[Tracked[In], Tracked[Out]]
+	handle func(input In, output Out) error
+
+	in       chan Tracked[In]
+	submitMu sync.Mutex
+	stopping chan struct{}
+	stopOnce sync.Once
+
+	mu        sync.Mutex
+	next      uint64
+	pending   map[uint64]In
+	abandoned bool
+	started   bool
+	cancel    context.CancelFunc
+
+	done chan struct{}
+	err  error
+}
+
+func NewPipeline[In, Out any](stage Stage[Tracked[In], Tracked[Out]], handle func(input In, output Out) error) *Pipeline[In, Out] {
+	return &Pipeline[In, Out]{
+		stage:    stage,
+		handle:   handle,
+		in:       make(chan Tracked[In]),
+		pending:  map[uint64]In{},
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// forget is called for dead letters, they are no longer in flight.
+func (p *Pipeline[In, Out]) forget(id uint64) (any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	input, ok := p.pending[id]
+	delete(p.pending, id)
+	return input, ok
+}
+
+func (p *Pipeline[In, Out]) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		return ErrPipelineStarted
+	}
+	p.started = true
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(p.done)
+		p.err = p.run(ctx)
+	}()
+	return nil
+}
+
+func (p *Pipeline[In, Out]) run(ctx context.Context) error {
+	group, ctx := newErrGroup(ctx)
+	out := make(chan Tracked[Out], 1)
+
+	// stages return once their input is closed, so a failed or cancelled
+	// pipeline stops accepting input as well
+	go func() {
+		<-ctx.Done()
+		p.closeInput()
+	}()
+
+	group.Go(func() error {
+		defer close(out)
+		err := p.stage(ctx, p.in, out)
+		go drain(p.in)
+		return err
+	})
+	group.Go(func() error {
+		for result := range out {
+			// once Stop has given up on the pipeline the item is reported as
+			// dropped and must not be handled as well
+			p.mu.Lock()
+			abandoned := p.abandoned
+			input, ok := p.pending[result.id]
+			delete(p.pending, result.id)
+			p.mu.Unlock()
+			if abandoned || !ok {
+				continue
+			}
+
+			if err := p.handle(input, result.Value); err != nil {
+				go drain(out)
+				return err
+			}
+		}
+		return nil
+	})
+
+	return group.Wait()
+}
+
+// Submit blocks until the stage accepts input, ctx is done or the pipeline
+// is stopped.
+func (p *Pipeline[In, Out]) Submit(ctx context.Context, input In) error {
+	p.submitMu.Lock()
+	defer p.submitMu.Unlock()
+
+	select {
+	case <-p.stopping:
+		return ErrPipelineStopped
+	default:
+	}
+
+	// the input is in flight before it is sent, its output may come right after
+	p.mu.Lock()
+	item := Tracked[In]{Value: input, id: p.next, owner: p}
+	p.next++
+	p.pending[item.id] = input
+	p.mu.Unlock()
+
+	select {
+	case p.in <- item:
+		return nil
+	case <-p.stopping:
+		p.unqueue(item.id)
+		return ErrPipelineStopped
+	case <-ctx.Done():
+		p.unqueue(item.id)
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline[In, Out]) unqueue(id uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+func (p *Pipeline[In, Out]) closeInput() {
+	p.stopOnce.Do(func() {
+		close(p.stopping)
+		p.submitMu.Lock()
+		close(p.in)
+		p.submitMu.Unlock()
+	})
+}
+
+// Stop stops accepting input and waits until the inputs in flight have
+// been handled. It returns the inputs, in submit order, whose outputs were
+// not handled, whichever stage they were dropped at. If ctx is done first,
+// the pipeline is cancelled and Stop returns right away with the ctx error;
+// Wait waits for stage calls that ignore the cancellation. Otherwise Stop
+// returns the error of the pipeline.
+func (p *Pipeline[In, Out]) Stop(ctx context.Context) ([]In, error) {
+	p.closeInput()
+
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+	if !started {
+		return nil, nil
+	}
+
+	var err error
+	select {
+	case <-p.done:
+		err = p.err
+	case <-ctx.Done():
+		p.cancel()
+		err = ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.abandoned = true
+	ids := make([]uint64, 0, len(p.pending))
+	for id := range p.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var dropped []In
+	for _, id := range ids {
+		dropped = append(dropped, p.pending[id])
+	}
+	p.pending = map[uint64]In{}
+	return dropped, err
+}
+
+// Wait blocks until the started pipeline finishes and returns its error.
+func (p *Pipeline[In, Out]) Wait() error {
+	<-p.done
+	return p.err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func slowTimesTen(delay time.Duration) func(ctx context.Context, input int) (int, error) {
+	return func(ctx context.Context, input int) (int, error) {
+		select {
+		case <-time.After(delay):
+			return input * 10, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+func TestPipelineLifecycle(t *testing.T) {
+	useFastSigners(t)
+
+	results := map[int]string{}
+	cfg := PoolConfig{Workers: 4}
+	pipeline := NewPipeline(Chain(TrackedSingleHashPool(cfg), TrackedMultiHashPool(cfg)), func(input int, output string) error {
+		results[input] = output
+		return nil
+	})
+
+	if err := pipeline.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := pipeline.Start(context.Background()); err != ErrPipelineStarted {
+		t.Errorf("expected %v, got %v", ErrPipelineStarted, err)
+	}
+	for _, input := range []int{0, 1} {
+		if err := pipeline.Submit(context.Background(), input); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	dropped, err := pipeline.Stop(context.Background())
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("unexpected stop result %v, %v", dropped, err)
+	}
+	if err := pipeline.Wait(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if expected := map[int]string{0: testHash0, 1: testHash1}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+	if err := pipeline.Submit(context.Background(), 2); err != ErrPipelineStopped {
+		t.Errorf("expected %v, got %v", ErrPipelineStopped, err)
+	}
+}
+
+func TestPipelineStopDeadline(t *testing.T) {
+	var mu sync.Mutex
+	var handled []int
+	pipeline := NewPipeline(Track("TimesTen", PoolConfig{}, slowTimesTen(50*time.Millisecond)), func(input, output int) error {
+		if output != input*10 {
+			t.Errorf("output %d does not match input %d", output, input)
+		}
+		mu.Lock()
+		handled = append(handled, input)
+		mu.Unlock()
+		return nil
+	})
+	pipeline.Start(context.Background())
+
+	// the single worker holds one item while the next one is submitted
+	for _, input := range []int{1, 2, 3} {
+		if err := pipeline.Submit(context.Background(), input); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dropped, err := pipeline.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if err := pipeline.Wait(); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dropped) == 0 || len(handled)+len(dropped) != 3 {
+		t.Errorf("expected every input to be handled or dropped, got %v and %v", handled, dropped)
+	}
+}
+
+func TestPipelineStopIgnoredCancel(t *testing.T) {
+	pipeline := NewPipeline(Track("Sleep", PoolConfig{}, func(ctx context.Context, input int) (int, error) {
+		time.Sleep(300 * time.Millisecond)
+		return input, nil
+	}), func(input, output int) error {
+		t.Errorf("input %d handled after Stop gave up", input)
+		return nil
+	})
+	pipeline.Start(context.Background())
+	pipeline.Submit(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	dropped, err := pipeline.Stop(ctx)
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Stop returned %v after its deadline", elapsed)
+	}
+	if err != context.DeadlineExceeded || !reflect.DeepEqual(dropped, []int{1}) {
+		t.Errorf("unexpected stop result %v, %v", dropped, err)
+	}
+	pipeline.Wait()
+}
+
+func TestPipelineDeadLetter(t *testing.T) {
+	useFastSigners(t)
+
+	var letters []DeadLetter
+	cfg := PoolConfig{DeadLetter: func(letter DeadLetter) { letters = append(letters, letter) }}
+	results := map[int]string{}
+	// the item panics after the hash stages and the dead letter still gets
+	// the submitted input
+	hashes := Chain(TrackedSingleHashPool(cfg), TrackedMultiHashPool(cfg))
+	pipeline := NewPipeline(Chain(hashes, Track("Check", cfg, func(ctx context.Context, input string) (string, error) {
+		if input != testHash0 {
+			panic("bad input")
+		}
+		return input, nil
+	})), func(input int, output string) error {
+		results[input] = output
+		return nil
+	})
+	pipeline.Start(context.Background())
+
+	for _, input := range []int{1, 0} {
+		if err := pipeline.Submit(context.Background(), input); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	dropped, err := pipeline.Stop(context.Background())
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("unexpected stop result %v, %v", dropped, err)
+	}
+	if expected := map[int]string{0: testHash0}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+	if len(letters) != 1 || letters[0].Item != 1 || letters[0].Stage != "Check" {
+		t.Errorf("unexpected dead letters %+v", letters)
+	}
+}
+
+func TestPipelineHandlerError(t *testing.T) {
+	failure := errors.New("handler failed")
+	pipeline := NewPipeline(Track("TimesTen", PoolConfig{}, slowTimesTen(time.Millisecond)), func(input, output int) error {
+		return failure
+	})
+	pipeline.Start(context.Background())
+
+	pipeline.Submit(context.Background(), 1)
+	if err := pipeline.Wait(); err != failure {
+		t.Errorf("expected %v, got %v", failure, err)
+	}
+	if _, err := pipeline.Stop(context.Background()); err != failure {
+		t.Errorf("expected %v, got %v", failure, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pipeline.Submit(ctx, 2); err != ErrPipelineStopped {
+		t.Errorf("expected %v, got %v", ErrPipelineStopped, err)
+	}
+}
+
+func TestPipelineStopLaterStage(t *testing.T) {
+	// the first stage drops odd inputs, the second one is slow
+	first := Track("Even", PoolConfig{}, func(ctx context.Context, input int) (int, error) {
+		if input%2 == 1 {
+			return 0, errSkipItem
+		}
+		return input, nil
+	})
+	pipeline := NewPipeline(Chain(first, Track("TimesTen", PoolConfig{}, slowTimesTen(50*time.Millisecond))), func(input, output int) error {
+		return nil
+	})
+	pipeline.Start(context.Background())
+	for _, input := range []int{1, 2, 3} {
+		if err := pipeline.Submit(context.Background(), input); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	dropped, err := pipeline.Stop(context.Background())
+	if err != nil || !reflect.DeepEqual(dropped, []int{1, 3}) {
+		t.Errorf("unexpected stop result %v, %v", dropped, err)
+	}
+
+	pipeline = NewPipeline(Chain(first, Track("TimesTen", PoolConfig{}, slowTimesTen(time.Second))), func(input, output int) error {
+		t.Errorf("input %d handled after Stop gave up", input)
+		return nil
+	})
+	pipeline.Start(context.Background())
+	pipeline.Submit(context.Background(), 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	dropped, err = pipeline.Stop(ctx)
+	if err != context.DeadlineExceeded || !reflect.DeepEqual(dropped, []int{2}) {
+		t.Errorf("unexpected stop result %v, %v", dropped, err)
+	}
+	pipeline.Wait()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var quietLogger = slog.New(discardHandler{})
+
+var currentLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger for the hash stages. Intermediate values are
+// logged at debug level with stage, item and step attributes; nil turns
+// logging off, which is the default.
+func SetLogger(logger *slog.Logger) {
+	currentLogger.Store(logger)
+}
+
+func getLogger() *slog.Logger {
+	if logger := currentLogger.Load(); logger != nil {
+		return logger
+	}
+	return quietLogger
+}
+
+// itemLogger tags records with the stage and the number of the item within
+// one run of the stage. The channels between the stages carry only the
+// hashes, so the number is not passed on: it is best-effort, and one input
+// is followed across stages by its data, since the data of MultiHash is the
+// result of SingleHash.
+func itemLogger(stage string, item uint64) *slog.Logger {
+	return getLogger().With("stage", stage, "item", item)
+}
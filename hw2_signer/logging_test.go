@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashLogging(t *testing.T) {
+	useFastSigners(t)
+
+	buf := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	_, err := RunStage(context.Background(), []int{0}, Chain(SingleHashStage, MultiHashStage))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	steps := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record struct {
+			Stage string
+			Item  uint64
+			Step  string
+			Value string
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		if record.Item != 0 {
+			t.Errorf("unexpected item in %q", line)
+		}
+		steps[record.Stage+" "+record.Step] = record.Value
+	}
+
+	expected := map[string]string{
+		"SingleHash md5(data)":        "cfcd208495d565ef66e7dff9f98764da",
+		"SingleHash crc32(md5(data))": "502633748",
+		"SingleHash result":           "4108050209~502633748",
+		"MultiHash result":            testHash0,
+	}
+	for step, value := range expected {
+		if steps[step] != value {
+			t.Errorf("step %q: got %q, expected %q", step, steps[step], value)
+		}
+	}
+}
+
+func TestHashLoggingQuietByDefault(t *testing.T) {
+	if getLogger().Enabled(context.Background(), slog.LevelError) {
+		t.Errorf("default logger should be quiet")
+	}
+}
+
+func TestHashLoggingItemIDs(t *testing.T) {
+	useFastSigners(t)
+
+	buf := new(bytes.Buffer)
+	SetLogger(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer SetLogger(nil)
+
+	stages := map[string]Stage[int, string]{
+		"stage": Chain(SingleHashStage, MultiHashStage),
+		"pool":  Chain(SingleHashPool(PoolConfig{Workers: 3}), MultiHashPool(PoolConfig{Workers: 3})),
+	}
+	for name, stage := range stages {
+		// the second run of the same stage numbers its items from 0 again
+		for run := 0; run < 2; run++ {
+			buf.Reset()
+			if _, err := RunStage(context.Background(), []int{0, 1, 1}, stage); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+
+			items := map[string]map[uint64]bool{}
+			results := map[string]int{}
+			inputs := map[string]int{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var record struct {
+					Stage string
+					Item  uint64
+					Step  string
+					Data  string
+					Value string
+				}
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("bad log line %q: %v", line, err)
+				}
+				if record.Step != "result" {
+					continue
+				}
+				if items[record.Stage] == nil {
+					items[record.Stage] = map[uint64]bool{}
+				}
+				items[record.Stage][record.Item] = true
+				switch record.Stage {
+				case "SingleHash":
+					results[record.Value]++
+				case "MultiHash":
+					inputs[record.Data]++
+				}
+			}
+
+			expectedItems := map[uint64]bool{0: true, 1: true, 2: true}
+			for _, stageName := range []string{"SingleHash", "MultiHash"} {
+				if !reflect.DeepEqual(items[stageName], expectedItems) {
+					t.Errorf("%s run %d: unexpected %s items %v", name, run, stageName, items[stageName])
+				}
+			}
+			// an input is followed from SingleHash to MultiHash by its data
+			if !reflect.DeepEqual(results, inputs) {
+				t.Errorf("%s run %d: MultiHash data %v does not match SingleHash results %v", name, run, inputs, results)
+			}
+		}
+	}
+}
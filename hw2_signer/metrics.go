@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10}
+
+// maxPendingReceipts bounds the memory used to match inputs with outputs of
+// stages that consume many items per output, like CombineResults.
+const maxPendingReceipts = 1024
+
+type HistogramSnapshot struct {
+	Bounds []float64 // upper bounds in seconds
+	Counts []uint64  // per bucket, the last one is +Inf
+	Count  uint64
+	Sum    float64
+}
+
+type StageSnapshot struct {
+	Index          int
+	Name           string
+	ItemsIn        uint64
+	ItemsOut       uint64
+	QueueDepth     int // items emitted by the previous stage and not taken yet
+	MaxQueueDepth  int
+	BlockedSend    time.Duration
+	BlockedReceive time.Duration
+	Latency        HistogramSnapshot
+}
+
+type stageMetrics struct {
+	mu       sync.Mutex
+	snapshot StageSnapshot
+	receipts []time.Time
+	lastOut  time.Time
+}
+
+func newStageMetrics(index int, name string) *stageMetrics {
+	stage := &stageMetrics{lastOut: time.Now()}
+	stage.snapshot.Index = index
+	stage.snapshot.Name = name
+	stage.snapshot.Latency.Bounds = defaultLatencyBuckets
+	stage.snapshot.Latency.Counts = make([]uint64, len(defaultLatencyBuckets)+1)
+	return stage
+}
+
+func (s *stageMetrics) setQueueDepth(depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.QueueDepth = depth
+	if depth > s.snapshot.MaxQueueDepth {
+		s.snapshot.MaxQueueDepth = depth
+	}
+}
+
+func (s *stageMetrics) received(now time.Time, depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.ItemsIn++
+	s.snapshot.QueueDepth = depth
+	if len(s.receipts) < maxPendingReceipts {
+		s.receipts = append(s.receipts, now)
+	}
+}
+
+// emitted records the latency of an output as the time since the oldest input
+// the stage has not answered yet, or since the previous output for stages that
+// produce more than they consume.
+func (s *stageMetrics) emitted(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := s.lastOut
+	if len(s.receipts) > 0 {
+		since = s.receipts[0]
+		s.receipts = s.receipts[1:]
+	}
+	s.lastOut = now
+	s.snapshot.ItemsOut++
+
+	latency := now.Sub(since).Seconds()
+	histogram := &s.snapshot.Latency
+	bucket := len(histogram.Bounds)
+	for i, bound := range histogram.Bounds {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+	histogram.Counts[bucket]++
+	histogram.Count++
+	histogram.Sum += latency
+}
+
+func (s *stageMetrics) addBlocked(send, receive time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.BlockedSend += send
+	s.snapshot.BlockedReceive += receive
+}
+
+func (s *stageMetrics) get() StageSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.snapshot
+	snapshot.Latency.Counts = append([]uint64(nil), s.snapshot.Latency.Counts...)
+	return snapshot
+}
+
+// PipelineMetrics collects per-stage statistics of ExecutePipelineMetrics.
+// Items are counted on the channels between jobs, so BlockedSend is the time
+// a stage's output waited for the next stage to take it, and BlockedReceive
+// the time a stage had nothing waiting on its input.
+type PipelineMetrics struct {
+	mu     sync.Mutex
+	stages []*stageMetrics
+}
+
+func NewPipelineMetrics() *PipelineMetrics {
+	return &PipelineMetrics{}
+}
+
+func (m *PipelineMetrics) reset(jobs []job) []*stageMetrics {
+	stages := make([]*stageMetrics, len(jobs))
+	for i, currentJob := range jobs {
+		stages[i] = newStageMetrics(i, getJobName(currentJob))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages = stages
+	return stages
+}
+
+func (m *PipelineMetrics) Snapshot() []StageSnapshot {
+	m.mu.Lock()
+	stages := m.stages
+	m.mu.Unlock()
+
+	snapshots := make([]StageSnapshot, 0, len(stages))
+	for _, stage := range stages {
+		snapshots = append(snapshots, stage.get())
+	}
+	return snapshots
+}
+
+func getJobName(currentJob job) string {
+	name := runtime.FuncForPC(reflect.ValueOf(currentJob).Pointer()).Name()
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
+	}
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return name
+}
+
+// forward moves items from the output of producer to the input of consumer,
+// a nil consumer drops them after counting.
+func forward(from, to chan interface{}, producer, consumer *stageMetrics) {
+	if to != nil {
+		defer close(to)
+	}
+
+	for {
+		start := time.Now()
+		item, ok := <-from
+		received := time.Now()
+		if consumer != nil {
+			consumer.addBlocked(0, received.Sub(start))
+		}
+		if !ok {
+			return
+		}
+		producer.emitted(received)
+		if consumer == nil {
+			continue
+		}
+
+		// the queue is the buffer of the producer's output plus the item
+		// held here until the consumer takes it
+		consumer.setQueueDepth(len(from) + 1)
+		to <- item
+		delivered := time.Now()
+		producer.addBlocked(delivered.Sub(received), 0)
+		consumer.received(delivered, len(from))
+	}
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the last snapshot in the Prometheus text format.
+func (m *PipelineMetrics) WritePrometheus(w io.Writer) error {
+	snapshots := m.Snapshot()
+
+	type metric struct {
+		name, help, kind string
+		value            func(StageSnapshot) float64
+	}
+	metrics := []metric{
+		{"signer_stage_items_in_total", "Items received by the stage.", "counter",
+			func(s StageSnapshot) float64 { return float64(s.ItemsIn) }},
+		{"signer_stage_items_out_total", "Items emitted by the stage.", "counter",
+			func(s StageSnapshot) float64 { return float64(s.ItemsOut) }},
+		{"signer_stage_queue_depth", "Items waiting on the stage input.", "gauge",
+			func(s StageSnapshot) float64 { return float64(s.QueueDepth) }},
+		{"signer_stage_max_queue_depth", "Largest number of items waiting on the stage input.", "gauge",
+			func(s StageSnapshot) float64 { return float64(s.MaxQueueDepth) }},
+		{"signer_stage_blocked_send_seconds_total", "Time the stage output waited for the next stage.", "counter",
+			func(s StageSnapshot) float64 { return s.BlockedSend.Seconds() }},
+		{"signer_stage_blocked_receive_seconds_total", "Time the stage input was empty.", "counter",
+			func(s StageSnapshot) float64 { return s.BlockedReceive.Seconds() }},
+	}
+
+	var b strings.Builder
+	labels := func(s StageSnapshot) string {
+		return fmt.Sprintf(`stage="%d",stage_name="%s"`, s.Index, promEscaper.Replace(s.Name))
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, s := range snapshots {
+			fmt.Fprintf(&b, "%s{%s} %s\n", metric.name, labels(s), formatFloat(metric.value(s)))
+		}
+	}
+
+	const latency = "signer_stage_latency_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time an item spent in the stage.\n# TYPE %s histogram\n", latency, latency)
+	for _, s := range snapshots {
+		var cumulative uint64
+		for i, count := range s.Latency.Counts {
+			cumulative += count
+			bound := "+Inf"
+			if i < len(s.Latency.Bounds) {
+				bound = formatFloat(s.Latency.Bounds[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", latency, labels(s), bound, cumulative)
+		}
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", latency, labels(s), formatFloat(s.Latency.Sum))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", latency, labels(s), s.Latency.Count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (m *PipelineMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineMetrics(t *testing.T) {
+	metrics := NewPipelineMetrics()
+
+	ExecutePipelineMetrics(metrics,
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 5; i++ {
+				out <- i
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				time.Sleep(20 * time.Millisecond)
+				out <- val
+			}
+		}),
+		job(sumInts),
+	)
+
+	snapshot := metrics.Snapshot()
+	if len(snapshot) != 3 {
+		t.Fatalf("expected 3 stages, got %d", len(snapshot))
+	}
+	source, slow, sink := snapshot[0], snapshot[1], snapshot[2]
+
+	if source.ItemsIn != 0 || source.ItemsOut != 5 || slow.ItemsIn != 5 || slow.ItemsOut != 5 || sink.ItemsIn != 5 || sink.ItemsOut != 1 {
+		t.Errorf("unexpected item counts: %+v", snapshot)
+	}
+	if source.BlockedSend < 40*time.Millisecond {
+		t.Errorf("source should wait for the slow stage, blocked for %s", source.BlockedSend)
+	}
+	if slow.Latency.Count != 5 || slow.Latency.Sum < 0.1 {
+		t.Errorf("unexpected latency of the slow stage: %+v", slow.Latency)
+	}
+	// the source runs ahead and fills the buffer in front of the slow stage
+	if slow.MaxQueueDepth != 2 || slow.QueueDepth != 0 {
+		t.Errorf("unexpected queue depth of the slow stage: %d now, %d at most", slow.QueueDepth, slow.MaxQueueDepth)
+	}
+	if sink.Name != "sumInts" {
+		t.Errorf("unexpected stage name %q", sink.Name)
+	}
+
+	out := new(strings.Builder)
+	if err := metrics.WritePrometheus(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`signer_stage_items_out_total{stage="1",stage_name="TestPipelineMetrics.func2"} 5`,
+		`signer_stage_latency_seconds_bucket{stage="1",stage_name="TestPipelineMetrics.func2",le="+Inf"} 5`,
+		`signer_stage_max_queue_depth{stage="1",stage_name="TestPipelineMetrics.func2"} 2`,
+		"# TYPE signer_stage_latency_seconds histogram",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("prometheus output has no line %q:\n%s", line, out)
+		}
+	}
+}
+
+func sumInts(in, out chan interface{}) {
+	sum := 0
+	for val := range in {
+		sum += val.(int)
+	}
+	out <- sum
+}
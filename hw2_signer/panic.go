@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is a panic in a job or stage turned into a pipeline error.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// catchPanic must be deferred directly, it replaces *err with a PanicError
+// when the function panics.
+func catchPanic(err *error) {
+	if value := recover(); value != nil {
+		*err = newPanicError(value)
+	}
+}
+
+// newPanicError keeps a PanicError passed on by panicking with it again.
+func newPanicError(value interface{}) *PanicError {
+	if panicErr, ok := value.(*PanicError); ok {
+		return panicErr
+	}
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+// firstPanic collects panics from goroutines that have no error to return
+// them in; the one that waits for them panics again with the first.
+type firstPanic struct {
+	mu  sync.Mutex
+	err *PanicError
+}
+
+func (p *firstPanic) keep(err *PanicError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// catch must be deferred directly.
+func (p *firstPanic) catch() {
+	if value := recover(); value != nil {
+		p.keep(newPanicError(value))
+	}
+}
+
+func (p *firstPanic) repanic() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		panic(p.err)
+	}
+}
+
+func callJob(currentJob job, in, out chan interface{}) (err error) {
+	defer catchPanic(&err)
+	currentJob(in, out)
+	return nil
+}
+
+func callContextJob(ctx context.Context, currentJob contextJob, in, out chan interface{}) (err error) {
+	defer catchPanic(&err)
+	return currentJob(ctx, in, out)
+}
+
+func callItem[In, Out any](ctx context.Context, fn func(ctx context.Context, input In) (Out, error), input In) (output Out, err error) {
+	defer catchPanic(&err)
+	return fn(ctx, input)
+}
+
+// RestartOnPanic starts the job again on the same channels when it
+// panics, at most restarts times. The item the job was busy with is lost,
+// the next run continues with the rest of the input.
+func RestartOnPanic(name string, currentJob contextJob, restarts int) contextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for restart := 0; ; restart++ {
+			err := callContextJob(ctx, currentJob, in, out)
+			var panicErr *PanicError
+			if !errors.As(err, &panicErr) || restart >= restarts || ctx.Err() != nil {
+				return err
+			}
+			getLogger().Warn("restarting job after panic", "job", name, "restart", restart+1, "panic", fmt.Sprint(panicErr.Value))
+		}
+	}
+}
+
+// errSkipItem makes ParallelMap and OrderedParallelMap drop the item
+// without emitting anything for it.
+var errSkipItem = errors.New("item skipped")
+
+// deadLetterPanics sends the items fn panics on to sink and skips them.
+func deadLetterPanics[In, Out any](stage string, sink DeadLetterSink, fn func(ctx context.Context, input In) (Out, error)) func(ctx context.Context, input In) (Out, error) {
+	if sink == nil {
+		return fn
+	}
+	return func(ctx context.Context, input In) (Out, error) {
+		output, err := callItem(ctx, fn, input)
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			sink(DeadLetter{Stage: stage, Item: input, Err: err})
+			return output, errSkipItem
+		}
+		return output, err
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func panickingJob(ctx context.Context, in, out chan interface{}) error {
+	for value := range in {
+		if value == "bad" {
+			panic("bad item")
+		}
+		if err := send(ctx, out, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPanicError(t *testing.T) {
+	err := ExecutePipelineContext(context.Background(),
+		sourceJob("good", "bad", "good"),
+		panickingJob,
+		func(ctx context.Context, in, out chan interface{}) error {
+			drain(in)
+			return nil
+		},
+	)
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if panicErr.Value != "bad item" || !strings.Contains(string(panicErr.Stack), "panickingJob") {
+		t.Errorf("unexpected panic %v\n%s", panicErr.Value, panicErr.Stack)
+	}
+}
+
+func TestPanicLegacyJob(t *testing.T) {
+	legacy := job(func(in, out chan interface{}) {
+		for range in {
+			panic("legacy")
+		}
+	})
+
+	var panicErr *PanicError
+	err := ExecutePipelineContext(context.Background(), sourceJob(1), withContext(legacy))
+	if !errors.As(err, &panicErr) || panicErr.Value != "legacy" {
+		t.Errorf("expected legacy panic, got %v", err)
+	}
+
+	var result interface{}
+	recovered := recoverPanic(func() {
+		ExecutePipeline(
+			func(in, out chan interface{}) {
+				out <- 1
+				out <- 2
+			},
+			legacy,
+			func(in, out chan interface{}) {
+				for value := range in {
+					result = value
+				}
+			},
+		)
+	})
+	if !errors.As(recovered, &panicErr) || panicErr.Value != "legacy" {
+		t.Errorf("expected ExecutePipeline to panic with the job panic, got %v", recovered)
+	}
+	if result != nil {
+		t.Errorf("expected nothing after the panicked job, got %v", result)
+	}
+}
+
+func recoverPanic(fn func()) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err, _ = value.(error)
+		}
+	}()
+	fn()
+	return nil
+}
+
+func TestPanicLegacyWorker(t *testing.T) {
+	useFastSigners(t)
+	crc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		// "0x" is the first round of MultiHash for "x"
+		if data == "1" || data == "0x" {
+			panic("crc32 failed")
+		}
+		return crc32(data)
+	}
+
+	var panicErr *PanicError
+	var sink collector
+	err := ExecutePipelineContext(context.Background(), sourceJob(0, 1), withContext(SingleHash), sink.job)
+	if !errors.As(err, &panicErr) || panicErr.Value != "crc32 failed" {
+		t.Errorf("expected the SingleHash worker panic, got %v", err)
+	}
+
+	recovered := recoverPanic(func() {
+		ExecutePipeline(
+			func(in, out chan interface{}) {
+				out <- "x"
+			},
+			MultiHash,
+		)
+	})
+	if !errors.As(recovered, &panicErr) || panicErr.Value != "crc32 failed" {
+		t.Errorf("expected the MultiHash worker panic, got %v", recovered)
+	}
+}
+
+func TestRestartOnPanic(t *testing.T) {
+	var sink collector
+	err := ExecutePipelineContext(context.Background(),
+		sourceJob("a", "bad", "b", "bad", "c"),
+		RestartOnPanic("panicking", panickingJob, 2),
+		sink.job,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(sink.values, expected) {
+		t.Errorf("expected %v, got %v", expected, sink.values)
+	}
+
+	err = ExecutePipelineContext(context.Background(),
+		sourceJob("bad", "bad"),
+		RestartOnPanic("panicking", panickingJob, 1),
+		sink.job,
+	)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Errorf("expected PanicError after restarts run out, got %v", err)
+	}
+}
+
+func TestPoolDeadLetterPanics(t *testing.T) {
+	useFastSigners(t)
+
+	md5 := func(ctx context.Context, data string) (string, error) {
+		if data == "1" {
+			panic("md5 overheated")
+		}
+		return DataSignerMd5(data), nil
+	}
+
+	for _, ordered := range []bool{false, true} {
+		mu := &sync.Mutex{}
+		var letters []DeadLetter
+		cfg := PoolConfig{Workers: 2, Ordered: ordered, Md5: md5, DeadLetter: func(letter DeadLetter) {
+			mu.Lock()
+			defer mu.Unlock()
+			letters = append(letters, letter)
+		}}
+
+		results, err := RunStage(context.Background(), []int{0, 1, 0}, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := []string{testHash0, testHash0}; !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected %v, got %v", expected, results)
+		}
+		if len(letters) != 1 || letters[0].Item != 1 || letters[0].Stage != "SingleHash" {
+			t.Errorf("unexpected dead letters %+v", letters)
+		}
+
+		cfg.DeadLetter = nil
+		_, err = RunStage(context.Background(), []int{0, 1, 0}, SingleHashPool(cfg))
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			t.Errorf("expected PanicError without dead letters, got %v", err)
+		}
+	}
+}
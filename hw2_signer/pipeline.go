@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+type contextJob func(ctx context.Context, in, out chan interface{}) error
+
+// errGroup runs goroutines that share a context, which is cancelled as soon
+// as one of them returns an error or panics. Wait returns the first error.
+type errGroup struct {
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+	cancel context.CancelFunc
+}
+
+func newErrGroup(ctx context.Context) (*errGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &errGroup{cancel: cancel}, ctx
+}
+
+func (g *errGroup) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := callGroup(f); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+func callGroup(f func() error) (err error) {
+	defer catchPanic(&err)
+	return f()
+}
+
+func (g *errGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func send(ctx context.Context, out chan interface{}, value interface{}) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExecutePipelineContext works like ExecutePipeline, but a job can fail:
+// the first error cancels ctx for every job and is returned once all of them
+// have stopped. A panic in a job fails the pipeline with a PanicError.
+func ExecutePipelineContext(ctx context.Context, jobs ...contextJob) error {
+	group, ctx := newErrGroup(ctx)
+
+	in := make(chan interface{}, 1)
+	close(in)
+
+	for _, currentJob := range jobs {
+		out := make(chan interface{}, 1)
+
+		group.Go(func(currentJob contextJob, in, out chan interface{}) func() error {
+			return func() error {
+				defer close(out)
+				err := currentJob(ctx, in, out)
+				go drain(in)
+				return err
+			}
+		}(currentJob, in, out))
+
+		in = out
+	}
+
+	return group.Wait()
+}
+
+// withContext adapts a legacy job: it can't fail or stop early, it only has
+// its input closed when the pipeline is cancelled.
+func withContext(currentJob job) contextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		guardedIn := make(chan interface{})
+		guardedOut := make(chan interface{})
+		var jobErr error
+
+		go func() {
+			defer close(guardedIn)
+			for input := range in {
+				if send(ctx, guardedIn, input) != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer close(guardedOut)
+			jobErr = callJob(currentJob, guardedIn, guardedOut)
+		}()
+
+		for output := range guardedOut {
+			if err := send(ctx, out, output); err != nil {
+				go drain(guardedOut)
+				return err
+			}
+		}
+		if jobErr != nil {
+			return jobErr
+		}
+		return ctx.Err()
+	}
+}
+
+func SingleHashContext(ctx context.Context, in, out chan interface{}) error {
+	return StageJob("SingleHash", SingleHashStage)(ctx, in, out)
+}
+
+func MultiHashContext(ctx context.Context, in, out chan interface{}) error {
+	return StageJob("MultiHash", MultiHashStage)(ctx, in, out)
+}
+
+func CombineResultsContext(ctx context.Context, in, out chan interface{}) error {
+	return StageJob("CombineResults", CombineResultsStage)(ctx, in, out)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testHash0 = "29568666068035183841425683795340791879727309630931025356555"
+	testHash1 = "4958044192186797981418233587017209679042592862002427381542"
+)
+
+// useFastSigners replaces the signers with the same hashes minus the sleeps
+func useFastSigners(t *testing.T) {
+	defaultMd5, defaultCrc32 := DataSignerMd5, DataSignerCrc32
+	t.Cleanup(func() {
+		DataSignerMd5, DataSignerCrc32 = defaultMd5, defaultCrc32
+	})
+
+	DataSignerMd5 = func(data string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(data+DataSignerSalt)))
+	}
+	DataSignerCrc32 = func(data string) string {
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data+DataSignerSalt))), 10)
+	}
+}
+
+func TestPipelineContext(t *testing.T) {
+	useFastSigners(t)
+
+	var result interface{}
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for _, input := range []int{1, 0} {
+				if err := send(ctx, out, input); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		SingleHashContext,
+		MultiHashContext,
+		CombineResultsContext,
+		func(ctx context.Context, in, out chan interface{}) error {
+			result = <-in
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := testHash0 + "_" + testHash1; result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestPipelineContextError(t *testing.T) {
+	errStage := errors.New("stage failed")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ExecutePipelineContext(context.Background(),
+			func(ctx context.Context, in, out chan interface{}) error {
+				for i := 0; ; i++ {
+					if err := send(ctx, out, i); err != nil {
+						return err
+					}
+				}
+			},
+			func(ctx context.Context, in, out chan interface{}) error {
+				for input := range in {
+					if input.(int) == 3 {
+						return errStage
+					}
+					if err := send(ctx, out, input); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			withContext(func(in, out chan interface{}) {
+				for range in {
+				}
+			}),
+		)
+	}()
+
+	select {
+	case err := <-done:
+		if err != errStage {
+			t.Errorf("expected %v, got %v", errStage, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pipeline was not cancelled")
+	}
+}
+
+func TestPipelineContextBadInput(t *testing.T) {
+	useFastSigners(t)
+
+	err := ExecutePipelineContext(context.Background(),
+		withContext(func(in, out chan interface{}) {
+			out <- 1
+			out <- "not a number"
+			out <- 2
+		}),
+		SingleHashContext,
+		CombineResultsContext,
+	)
+	if err == nil || err.Error() != "SingleHash: expected int, got string" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
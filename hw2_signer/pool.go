@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// PoolConfig bounds the resources of a hashing stage.
+type PoolConfig struct {
+	// Workers is the number of items processed at once.
+	Workers int
+	// HashWorkers limits the crc32 calls in flight across the stage, zero
+	// means no extra limit.
+	HashWorkers int
+	// Ordered stages emit results in input order.
+	Ordered bool
+
+	// Md5 and Crc32 replace the recipe algorithms, for example with a
+	// NewResilientSigner wrapper; an error from them fails the stage.
+	Md5   SignerFunc
+	Crc32 SignerFunc
+
+	// Md5Scheduler replaces the shared Md5Scheduler, which is only used
+	// when the inner algorithm is md5 or Md5 is set.
+	Md5Scheduler   *Scheduler
+	Crc32Scheduler *Scheduler
+
+	// A cache hit skips the scheduler.
+	Md5Cache   *SignerCache
+	Crc32Cache *SignerCache
+
+	// Recipe replaces DefaultRecipe. An invalid recipe fails the stage with
+	// the error of Validate.
+	Recipe *Recipe
+
+	// DeadLetter receives the items the stage panics on, which are skipped
+	// instead of failing the stage.
+	DeadLetter DeadLetterSink
+}
+
+func (cfg PoolConfig) workers() int {
+	if cfg.Workers < 1 {
+		return 1
+	}
+	return cfg.Workers
+}
+
+type semaphore chan struct{}
+
+func newSemaphore(size int) semaphore {
+	if size < 1 {
+		return nil
+	}
+	return make(semaphore, size)
+}
+
+func (cfg PoolConfig) recipe() Recipe {
+	if cfg.Recipe != nil {
+		return *cfg.Recipe
+	}
+	return DefaultRecipe
+}
+
+func (cfg PoolConfig) md5() SignerFunc {
+	inner := cfg.recipe().Inner
+	scheduler := cfg.Md5Scheduler
+	if scheduler == nil && (inner == "md5" || cfg.Md5 != nil) {
+		scheduler = Md5Scheduler
+	}
+	signer := getAlgorithm(inner)
+	if cfg.Md5 != nil {
+		signer = cfg.Md5
+	}
+	if scheduler != nil {
+		signer = scheduler.Wrap(signer)
+	}
+	if cfg.Md5Cache != nil {
+		return cfg.Md5Cache.wrap(inner, signer)
+	}
+	return signer
+}
+
+func (cfg PoolConfig) crc32(algorithm string) SignerFunc {
+	signer := getAlgorithm(algorithm)
+	if cfg.Crc32 != nil {
+		signer = cfg.Crc32
+	}
+	if cfg.Crc32Scheduler != nil {
+		signer = cfg.Crc32Scheduler.Wrap(signer)
+	}
+	if cfg.Crc32Cache != nil {
+		return cfg.Crc32Cache.wrap(algorithm, signer)
+	}
+	return signer
+}
+
+// limit bounds the number of concurrent calls of signer.
+func (s semaphore) limit(signer SignerFunc) SignerFunc {
+	if s == nil {
+		return signer
+	}
+	return func(ctx context.Context, data string) (string, error) {
+		select {
+		case s <- struct{}{}:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		defer func() { <-s }()
+		return signer(ctx, data)
+	}
+}
+
+// ParallelMap runs fn on a fixed number of workers. When all of them are
+// blocked on sending, nobody reads in, so a slow consumer slows down the
+// producer instead of piling up goroutines.
+func ParallelMap[In, Out any](workers int, fn func(ctx context.Context, input In) (Out, error)) Stage[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		group, ctx := newErrGroup(ctx)
+
+		for i := 0; i < workers; i++ {
+			group.Go(func() error {
+				for {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case input, ok := <-in:
+						if !ok {
+							return nil
+						}
+						output, err := fn(ctx, input)
+						if err == errSkipItem {
+							continue
+						}
+						if err != nil {
+							return err
+						}
+						if err := sendTo(ctx, out, output); err != nil {
+							return err
+						}
+					}
+				}
+			})
+		}
+
+		return group.Wait()
+	}
+}
+
+// OrderedParallelMap runs fn on up to workers items at once like ParallelMap,
+// but emits the results in the order the inputs arrived. A slow item holds
+// back the ones after it, and no more than workers items are started ahead.
+func OrderedParallelMap[In, Out any](workers int, fn func(ctx context.Context, input In) (Out, error)) Stage[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		output Out
+		err    error
+	}
+
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		group, ctx := newErrGroup(ctx)
+		items := &sync.WaitGroup{}
+		slots := make(semaphore, workers)
+		pending := make(chan chan result, workers)
+
+		group.Go(func() error {
+			defer close(pending)
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case input, ok := <-in:
+					if !ok {
+						return nil
+					}
+					select {
+					case slots <- struct{}{}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+
+					future := make(chan result, 1)
+					pending <- future
+					items.Add(1)
+					go func(input In) {
+						defer items.Done()
+						output, err := callItem(ctx, fn, input)
+						future <- result{output, err}
+					}(input)
+				}
+			}
+		})
+		group.Go(func() error {
+			for future := range pending {
+				result := <-future
+				<-slots
+				if result.err == errSkipItem {
+					continue
+				}
+				if result.err != nil {
+					return result.err
+				}
+				if err := sendTo(ctx, out, result.output); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		err := group.Wait()
+		// items already started may still be running after an error
+		items.Wait()
+		return err
+	}
+}
+
+func poolStage[In, Out any](name string, cfg PoolConfig, fn func(ctx context.Context, input In) (Out, error)) Stage[In, Out] {
+	fn = deadLetterPanics(name, cfg.DeadLetter, fn)
+	if cfg.Ordered {
+		return OrderedParallelMap(cfg.workers(), fn)
+	}
+	return ParallelMap(cfg.workers(), fn)
+}
+
+// countedPoolStage numbers the items of every run of the stage from 0 for
+// itemLogger.
+func countedPoolStage[In, Out any](name string, cfg PoolConfig, fn func(ctx context.Context, item uint64, input In) (Out, error)) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		var item uint64
+		return poolStage(name, cfg, func(ctx context.Context, input In) (Out, error) {
+			return fn(ctx, atomic.AddUint64(&item, 1)-1, input)
+		})(ctx, in, out)
+	}
+}
+
+// failedStage is built from a config that can not work and only returns
+// err.
+func failedStage[In, Out any](err error) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		return err
+	}
+}
+
+func SingleHashPool(cfg PoolConfig) Stage[int, string] {
+	return singleHashPool(cfg, strconv.Itoa)
+}
+
+// SingleHashStringPool signs arbitrary strings instead of numbers.
+func SingleHashStringPool(cfg PoolConfig) Stage[string, string] {
+	return singleHashPool(cfg, func(input string) string { return input })
+}
+
+func singleHashPool[In any](cfg PoolConfig, format func(input In) string) Stage[In, string] {
+	fn, err := singleHashFunc(cfg, format)
+	if err != nil {
+		return failedStage[In, string](err)
+	}
+	return countedPoolStage("SingleHash", cfg, fn)
+}
+
+// TrackedSingleHashPool is SingleHashPool for a Pipeline.
+func TrackedSingleHashPool(cfg PoolConfig) Stage[Tracked[int], Tracked[string]] {
+	fn, err := singleHashFunc(cfg, strconv.Itoa)
+	if err != nil {
+		return failedStage[Tracked[int], Tracked[string]](err)
+	}
+	return trackedPoolStage("SingleHash", cfg, fn)
+}
+
+func singleHashFunc[In any](cfg PoolConfig, format func(input In) string) (func(ctx context.Context, item uint64, input In) (string, error), error) {
+	recipe := cfg.recipe()
+	if err := recipe.Validate(); err != nil {
+		return nil, err
+	}
+	md5 := cfg.md5()
+	crc32 := newSemaphore(cfg.HashWorkers).limit(cfg.crc32(recipe.Outer))
+
+	return func(ctx context.Context, item uint64, input In) (string, error) {
+		return signSingleHash(ctx, itemLogger("SingleHash", item), recipe, format(input), md5, crc32)
+	}, nil
+}
+
+func MultiHashPool(cfg PoolConfig) Stage[string, string] {
+	fn, err := multiHashFunc(cfg)
+	if err != nil {
+		return failedStage[string, string](err)
+	}
+	return countedPoolStage("MultiHash", cfg, fn)
+}
+
+// TrackedMultiHashPool is MultiHashPool for a Pipeline.
+func TrackedMultiHashPool(cfg PoolConfig) Stage[Tracked[string], Tracked[string]] {
+	fn, err := multiHashFunc(cfg)
+	if err != nil {
+		return failedStage[Tracked[string], Tracked[string]](err)
+	}
+	return trackedPoolStage("MultiHash", cfg, fn)
+}
+
+func multiHashFunc(cfg PoolConfig) (func(ctx context.Context, item uint64, input string) (string, error), error) {
+	recipe := cfg.recipe()
+	if err := recipe.Validate(); err != nil {
+		return nil, err
+	}
+	crc32 := newSemaphore(cfg.HashWorkers).limit(cfg.crc32(recipe.Round))
+
+	return func(ctx context.Context, item uint64, input string) (string, error) {
+		return signMultiHash(ctx, itemLogger("MultiHash", item), recipe, input, crc32)
+	}, nil
+}
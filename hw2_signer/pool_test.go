@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolLimits(t *testing.T) {
+	useFastSigners(t)
+
+	var running, maxRunning int32
+	fastCrc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return fastCrc32(data)
+	}
+
+	inputs := make([]int, 50)
+	for i := range inputs {
+		inputs[i] = i % 2
+	}
+
+	cfg := PoolConfig{Workers: 4, HashWorkers: 3}
+	results, err := RunStage(context.Background(), inputs, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("expected %d results, got %d", len(inputs), len(results))
+	}
+	for _, result := range results {
+		if result != testHash0 && result != testHash1 {
+			t.Errorf("unexpected hash %s", result)
+		}
+	}
+	// both stages have their own limit of 3
+	if maxRunning > 6 {
+		t.Errorf("too many DataSignerCrc32 calls at once: %d", maxRunning)
+	}
+}
+
+func TestPoolBackPressure(t *testing.T) {
+	var produced int32
+	stage := ParallelMap(2, func(ctx context.Context, input int) (int, error) {
+		return input, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := make(chan int)
+	done := make(chan error, 1)
+	go func() { done <- stage(ctx, in, out) }()
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case in <- i:
+				atomic.AddInt32(&produced, 1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// nobody reads out, so each of the two workers takes one item and blocks
+	time.Sleep(50 * time.Millisecond)
+	if count := atomic.LoadInt32(&produced); count > 2 {
+		t.Errorf("stage kept reading without back-pressure: %d items", count)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestOrderedParallelMap(t *testing.T) {
+	var running, maxRunning int32
+	stage := OrderedParallelMap(4, func(ctx context.Context, input int) (int, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if current > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, current)
+		}
+		time.Sleep(time.Duration(input%5) * time.Millisecond)
+		return input * 10, nil
+	})
+
+	inputs := make([]int, 40)
+	for i := range inputs {
+		inputs[i] = len(inputs) - i
+	}
+
+	results, err := RunStage(context.Background(), inputs, stage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, result := range results {
+		if result != inputs[i]*10 {
+			t.Fatalf("result %d out of order: got %d, expected %d", i, result, inputs[i]*10)
+		}
+	}
+	if maxRunning > 4 {
+		t.Errorf("too many items in flight: %d", maxRunning)
+	}
+}
+
+func TestOrderedHashPool(t *testing.T) {
+	useFastSigners(t)
+
+	inputs := []int{1, 0, 0, 1, 1, 0}
+	cfg := PoolConfig{Workers: 3, Ordered: true}
+	results, err := RunStage(context.Background(), inputs, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[int]string{0: testHash0, 1: testHash1}
+	for i, result := range results {
+		if result != expected[inputs[i]] {
+			t.Errorf("result %d out of order: got %s, expected %s", i, result, expected[inputs[i]])
+		}
+	}
+}
+
+func TestParallelMapNoWorkers(t *testing.T) {
+	double := func(ctx context.Context, input int) (int, error) {
+		return input * 2, nil
+	}
+	for _, stage := range []Stage[int, int]{ParallelMap(0, double), OrderedParallelMap(-1, double)} {
+		results, err := RunStage(context.Background(), []int{1, 2, 3}, stage)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(results)
+		if !reflect.DeepEqual(results, []int{2, 4, 6}) {
+			t.Errorf("expected every input to be processed, got %v", results)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Recipe describes how items are signed. SingleHash returns
+// Outer(data) + Separator + Outer(Inner(data)), MultiHash joins
+// Round(th + data) for th from 0 to Rounds-1 with RoundSeparator.
+type Recipe struct {
+	Inner          string `json:"inner"`
+	Outer          string `json:"outer"`
+	Separator      string `json:"separator"`
+	Round          string `json:"round"`
+	Rounds         int    `json:"rounds"`
+	RoundSeparator string `json:"round_separator"`
+}
+
+var DefaultRecipe = Recipe{
+	Inner:     "md5",
+	Outer:     "crc32",
+	Separator: "~",
+	Round:     "crc32",
+	Rounds:    6,
+}
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]SignerFunc{
+		"md5":    defaultMd5,
+		"crc32":  defaultCrc32,
+		"sha256": hashSigner(func(data []byte) string { return fmt.Sprintf("%x", sha256.Sum256(data)) }),
+		"crc32c": hashSigner(func(data []byte) string {
+			return strconv.FormatUint(uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), 10)
+		}),
+		"xxhash": hashSigner(func(data []byte) string { return fmt.Sprintf("%016x", xxhash64(data)) }),
+	}
+)
+
+// hashSigner salts the data the same way DataSignerMd5 and DataSignerCrc32 do.
+func hashSigner(hash func(data []byte) string) SignerFunc {
+	return func(ctx context.Context, data string) (string, error) {
+		return hash([]byte(data + DataSignerSalt)), nil
+	}
+}
+
+// RegisterAlgorithm makes signer available to recipes under name,
+// replacing the previous one if any.
+func RegisterAlgorithm(name string, signer SignerFunc) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	algorithms[name] = signer
+}
+
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	var names []string
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getAlgorithm looks the signer up on every call, so the md5 and crc32
+// algorithms follow DataSignerMd5 and DataSignerCrc32 when they are replaced.
+func getAlgorithm(name string) SignerFunc {
+	return func(ctx context.Context, data string) (string, error) {
+		algorithmsMu.RLock()
+		signer, ok := algorithms[name]
+		algorithmsMu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("unknown algorithm %q", name)
+		}
+		return signer(ctx, data)
+	}
+}
+
+// maxRecipeRounds bounds the goroutines MultiHash starts for one item.
+const maxRecipeRounds = 64
+
+func (r Recipe) Validate() error {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	for _, name := range []string{r.Inner, r.Outer, r.Round} {
+		if _, ok := algorithms[name]; !ok {
+			return fmt.Errorf("unknown algorithm %q", name)
+		}
+	}
+	if r.Rounds < 1 || r.Rounds > maxRecipeRounds {
+		return fmt.Errorf("recipe needs 1 to %d rounds, got %d", maxRecipeRounds, r.Rounds)
+	}
+	return nil
+}
+
+// LoadRecipe reads a JSON recipe, fields missing from it keep the values
+// of DefaultRecipe.
+func LoadRecipe(r io.Reader) (Recipe, error) {
+	recipe := DefaultRecipe
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&recipe); err != nil {
+		return Recipe{}, fmt.Errorf("can not read recipe: %w", err)
+	}
+	if err := recipe.Validate(); err != nil {
+		return Recipe{}, err
+	}
+	return recipe, nil
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxRound(acc, lane uint64) uint64 {
+	acc += lane * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// xxhash64 is XXH64 with seed 0.
+func xxhash64(data []byte) uint64 {
+	length := uint64(len(data))
+	var h uint64
+
+	if len(data) >= 32 {
+		var seed uint64
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(data) >= 32; data = data[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(data[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += length
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
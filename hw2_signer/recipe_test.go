@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXxhash64(t *testing.T) {
+	cases := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for data, expected := range cases {
+		if got := xxhash64([]byte(data)); got != expected {
+			t.Errorf("xxhash64(%q): expected %x, got %x", data, expected, got)
+		}
+	}
+}
+
+func TestDefaultRecipe(t *testing.T) {
+	useFastSigners(t)
+
+	cfg := PoolConfig{Recipe: &DefaultRecipe}
+	results, err := RunStage(context.Background(), []int{0, 1}, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if combined := combineResults(results); combined != testHash0+"_"+testHash1 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", combined, testHash0+"_"+testHash1)
+	}
+}
+
+func TestCustomRecipe(t *testing.T) {
+	recipe, err := LoadRecipe(strings.NewReader(`{"inner": "sha256", "outer": "xxhash", "separator": "-", "rounds": 2, "round_separator": ":"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recipe.Round != "crc32" {
+		t.Errorf("expected missing round algorithm to default to crc32, got %q", recipe.Round)
+	}
+	recipe.Round = "crc32c"
+
+	cfg := PoolConfig{Recipe: &recipe}
+	results, err := RunStage(context.Background(), []int{7}, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	xxhash := func(data string) string { return fmt.Sprintf("%016x", xxhash64([]byte(data))) }
+	crc32c := func(data string) string {
+		value, _ := getAlgorithm("crc32c")(context.Background(), data)
+		return value
+	}
+	single := xxhash("7") + "-" + xxhash(fmt.Sprintf("%x", sha256.Sum256([]byte("7"))))
+	expected := crc32c("0"+single) + ":" + crc32c("1"+single)
+	if len(results) != 1 || results[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+	if _, err := strconv.ParseUint(crc32c("7"), 10, 32); err != nil {
+		t.Errorf("expected crc32c to be a decimal number: %v", err)
+	}
+}
+
+func TestRecipeErrors(t *testing.T) {
+	cases := []string{
+		`{"inner": "sha512"}`,
+		`{"rounds": 0}`,
+		`{"rounds": 65}`,
+		`{"unknown": 1}`,
+		`{`,
+	}
+	for _, input := range cases {
+		if _, err := LoadRecipe(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+
+	recipe := DefaultRecipe
+	recipe.Outer = "missing"
+	_, err := RunStage(context.Background(), []int{1}, SingleHashPool(PoolConfig{Recipe: &recipe, Md5: FromDataSigner(strings.ToUpper)}))
+	if err == nil || !strings.Contains(err.Error(), `unknown algorithm "missing"`) {
+		t.Errorf("expected unknown algorithm error, got %v", err)
+	}
+
+	for _, rounds := range []int{0, -1} {
+		recipe := DefaultRecipe
+		recipe.Rounds = rounds
+		cfg := PoolConfig{Recipe: &recipe}
+		_, err := RunStage(context.Background(), []string{"1"}, MultiHashPool(cfg))
+		if err == nil || !strings.Contains(err.Error(), "rounds") {
+			t.Errorf("rounds %d: expected rounds error, got %v", rounds, err)
+		}
+		_, err = RunStage(context.Background(), []int{1}, SingleHashPool(cfg))
+		if err == nil || !strings.Contains(err.Error(), "rounds") {
+			t.Errorf("rounds %d: expected rounds error from SingleHashPool, got %v", rounds, err)
+		}
+	}
+}
+
+func TestRecipeSkipsMd5Scheduler(t *testing.T) {
+	if err := Md5Scheduler.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer Md5Scheduler.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	recipe := Recipe{Inner: "sha256", Outer: "crc32c", Separator: "~", Round: "crc32c", Rounds: 1}
+	if _, err := RunStage(ctx, []int{1, 2}, SingleHashPool(PoolConfig{Workers: 2, Recipe: &recipe})); err != nil {
+		t.Errorf("sha256 should not wait for Md5Scheduler: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	recipe.Inner = "md5"
+	if _, err := RunStage(ctx, []int{1}, SingleHashPool(PoolConfig{Recipe: &recipe})); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("md5 should wait for Md5Scheduler, got %v", err)
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	RegisterAlgorithm("upper", FromDataSigner(strings.ToUpper))
+	t.Cleanup(func() {
+		algorithmsMu.Lock()
+		delete(algorithms, "upper")
+		algorithmsMu.Unlock()
+	})
+
+	recipe := Recipe{Inner: "upper", Outer: "upper", Separator: "|", Round: "upper", Rounds: 1}
+	if err := recipe.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err := RunStage(context.Background(), []int{5}, SingleHashPool(PoolConfig{Recipe: &recipe}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0] != "5|5" {
+		t.Errorf("unexpected results %v", results)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SignerFunc is a signer that can fail, like a remote signing service.
+type SignerFunc func(ctx context.Context, data string) (string, error)
+
+func FromDataSigner(signer func(string) string) SignerFunc {
+	return func(ctx context.Context, data string) (string, error) {
+		return signer(data), nil
+	}
+}
+
+var ErrCircuitOpen = errors.New("signer circuit breaker is open")
+
+// ResilienceConfig describes NewResilientSigner. Attempts is the total number
+// of calls for one item, the pause between them starts at InitialBackoff and
+// doubles up to MaxBackoff. A call longer than CallTimeout counts as failed.
+// After FailureThreshold failures in a row the breaker opens and calls fail
+// with ErrCircuitOpen for OpenTimeout, then a single probe call is let
+// through. A panic of the signer counts as a failure and is returned as a
+// PanicError without a retry. Zero values disable the corresponding feature.
+type ResilienceConfig struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	CallTimeout    time.Duration
+
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       circuitState
+	failures    int
+	openedAt    time.Time
+	threshold   int
+	openTimeout time.Duration
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.threshold < 1 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// a probe call is already in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+func (b *circuitBreaker) record(err error) {
+	if b.threshold < 1 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// skip gives up a call that ended through the caller's ctx, which says
+// nothing about the signer. A probe given up this way lets the next call
+// probe again.
+func (b *circuitBreaker) skip() {
+	if b.threshold < 1 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+	}
+}
+
+// callWithTimeout does not wait for a signer that ignores ctx past the
+// timeout, its result is dropped when it finally returns. A panic of the
+// signer is returned as a PanicError.
+func callWithTimeout(ctx context.Context, signer SignerFunc, data string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return callItem(ctx, signer, data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		hash string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		hash, err := callItem(ctx, signer, data)
+		done <- result{hash, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.hash, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// NewResilientSigner wraps signer with retries, a per-call timeout and a
+// circuit breaker shared by all calls of the returned signer.
+func NewResilientSigner(signer SignerFunc, cfg ResilienceConfig) SignerFunc {
+	breaker := &circuitBreaker{threshold: cfg.FailureThreshold, openTimeout: cfg.OpenTimeout}
+	attempts := cfg.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return func(ctx context.Context, data string) (string, error) {
+		backoff := cfg.InitialBackoff
+		var lastErr error
+
+		for attempt := 0; attempt < attempts; attempt++ {
+			if attempt > 0 && backoff > 0 {
+				timer := time.NewTimer(backoff)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return "", ctx.Err()
+				}
+				backoff *= 2
+				if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+					backoff = cfg.MaxBackoff
+				}
+			}
+
+			if err := breaker.allow(); err != nil {
+				return "", err
+			}
+			// only the per-call timeout counts as a failure of the signer,
+			// not the cancellation of the caller
+			hash, err := callWithTimeout(ctx, signer, data, cfg.CallTimeout)
+			if ctx.Err() != nil {
+				breaker.skip()
+				return "", ctx.Err()
+			}
+			breaker.record(err)
+			if err == nil {
+				return hash, nil
+			}
+			var panicErr *PanicError
+			if errors.As(err, &panicErr) {
+				return "", err
+			}
+			lastErr = err
+		}
+
+		return "", fmt.Errorf("signer failed after %d attempts: %w", attempts, lastErr)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errSignerDown = errors.New("signer is down")
+
+// fakeSigner fails the first failFirst calls, then answers "hash:"+data
+// after delay, unless the call is cancelled first.
+type fakeSigner struct {
+	mu        sync.Mutex
+	calls     int
+	failFirst int
+	delay     time.Duration
+}
+
+func (f *fakeSigner) Sign(ctx context.Context, data string) (string, error) {
+	f.mu.Lock()
+	f.calls++
+	fail := f.calls <= f.failFirst
+	f.mu.Unlock()
+
+	if fail {
+		return "", errSignerDown
+	}
+	select {
+	case <-time.After(f.delay):
+		return "hash:" + data, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (f *fakeSigner) getCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestResilientSignerRetry(t *testing.T) {
+	fake := &fakeSigner{failFirst: 2}
+	signer := NewResilientSigner(fake.Sign, ResilienceConfig{Attempts: 3, InitialBackoff: time.Millisecond})
+
+	hash, err := signer(context.Background(), "1")
+	if err != nil || hash != "hash:1" {
+		t.Errorf("expected hash:1, got %q, %v", hash, err)
+	}
+	if fake.getCalls() != 3 {
+		t.Errorf("expected 3 calls, got %d", fake.getCalls())
+	}
+
+	fake = &fakeSigner{failFirst: 5}
+	signer = NewResilientSigner(fake.Sign, ResilienceConfig{Attempts: 3})
+	if _, err := signer(context.Background(), "1"); !errors.Is(err, errSignerDown) {
+		t.Errorf("expected %v, got %v", errSignerDown, err)
+	}
+}
+
+func TestResilientSignerTimeout(t *testing.T) {
+	// the signer blocks until released or cancelled
+	release := make(chan struct{})
+	blocking := func(ctx context.Context, data string) (string, error) {
+		select {
+		case <-release:
+			return "hash:" + data, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	signer := NewResilientSigner(blocking, ResilienceConfig{Attempts: 2, CallTimeout: 10 * time.Millisecond})
+
+	start := time.Now()
+	_, err := signer(context.Background(), "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("timeout was not applied, took %s", elapsed)
+	}
+}
+
+func TestResilientSignerCircuitBreaker(t *testing.T) {
+	fake := &fakeSigner{failFirst: 2}
+	signer := NewResilientSigner(fake.Sign, ResilienceConfig{
+		Attempts:         1,
+		FailureThreshold: 2,
+		OpenTimeout:      20 * time.Millisecond,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := signer(context.Background(), "1"); !errors.Is(err, errSignerDown) {
+			t.Fatalf("call %d: expected %v, got %v", i, errSignerDown, err)
+		}
+	}
+	if _, err := signer(context.Background(), "1"); err != ErrCircuitOpen {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+	if fake.getCalls() != 2 {
+		t.Errorf("open circuit should not call the signer, calls: %d", fake.getCalls())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if hash, err := signer(context.Background(), "1"); err != nil || hash != "hash:1" {
+		t.Errorf("probe call failed: %q, %v", hash, err)
+	}
+	if _, err := signer(context.Background(), "2"); err != nil {
+		t.Errorf("circuit should be closed after a successful probe, got %v", err)
+	}
+}
+
+func TestPoolSignerFailure(t *testing.T) {
+	useFastSigners(t)
+
+	fake := &fakeSigner{failFirst: 1000}
+	cfg := PoolConfig{Workers: 2, Crc32: NewResilientSigner(fake.Sign, ResilienceConfig{Attempts: 2})}
+
+	_, err := RunStage(context.Background(), []int{0, 1, 2}, Chain(SingleHashPool(cfg), MultiHashPool(cfg)))
+	if err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("expected the signer error, got %v", err)
+	}
+}
+
+func TestResilientSignerPanic(t *testing.T) {
+	calls := 0
+	panicky := func(ctx context.Context, data string) (string, error) {
+		calls++
+		if calls <= 2 {
+			panic("signer bug")
+		}
+		return "hash:" + data, nil
+	}
+	signer := NewResilientSigner(panicky, ResilienceConfig{
+		Attempts:         3,
+		CallTimeout:      time.Second,
+		FailureThreshold: 1,
+		OpenTimeout:      10 * time.Millisecond,
+	})
+
+	var panicErr *PanicError
+	if _, err := signer(context.Background(), "1"); !errors.As(err, &panicErr) {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("a panic should not be retried, calls: %d", calls)
+	}
+	if _, err := signer(context.Background(), "1"); err != ErrCircuitOpen {
+		t.Errorf("a panic should open the circuit, got %v", err)
+	}
+
+	// the probe panics as well and the circuit opens again instead of
+	// staying half-open
+	time.Sleep(20 * time.Millisecond)
+	if _, err := signer(context.Background(), "1"); !errors.As(err, &panicErr) {
+		t.Fatalf("expected the probe PanicError, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if hash, err := signer(context.Background(), "1"); err != nil || hash != "hash:1" {
+		t.Errorf("probe call failed: %q, %v", hash, err)
+	}
+}
+
+func TestResilientSignerCallerCancel(t *testing.T) {
+	// the signer blocks until released or cancelled
+	release := make(chan struct{})
+	blocking := func(ctx context.Context, data string) (string, error) {
+		select {
+		case <-release:
+			return "hash:" + data, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	signer := NewResilientSigner(blocking, ResilienceConfig{
+		Attempts:         1,
+		CallTimeout:      time.Second,
+		FailureThreshold: 1,
+		OpenTimeout:      time.Minute,
+	})
+
+	for _, timeout := range []time.Duration{0, 10 * time.Millisecond} {
+		ctx, cancel := context.WithCancel(context.Background())
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		} else {
+			cancel()
+		}
+		_, err := signer(ctx, "1")
+		cancel()
+		if err != ctx.Err() {
+			t.Errorf("expected %v, got %v", ctx.Err(), err)
+		}
+	}
+
+	close(release)
+	if _, err := signer(context.Background(), "1"); err != nil {
+		t.Errorf("the caller's ctx should not open the circuit, got %v", err)
+	}
+}
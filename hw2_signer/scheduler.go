@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SchedulerConfig limits access to an exclusive resource such as
+// DataSignerMd5, which overheats when called concurrently. Concurrency is
+// the number of calls in flight (at least 1), Rate the number of calls
+// started per second with up to Burst of them at once; zero Rate means no
+// rate limit.
+type SchedulerConfig struct {
+	Concurrency int
+	Rate        float64
+	Burst       int
+}
+
+func (cfg SchedulerConfig) concurrency() int {
+	if cfg.Concurrency < 1 {
+		return 1
+	}
+	return cfg.Concurrency
+}
+
+func (cfg SchedulerConfig) burst() float64 {
+	if cfg.Burst < 1 {
+		return 1
+	}
+	return float64(cfg.Burst)
+}
+
+// Scheduler combines a concurrency limit with a token bucket. The limits can
+// be changed with SetConfig while calls are waiting.
+type Scheduler struct {
+	mu       sync.Mutex
+	cfg      SchedulerConfig
+	inFlight int
+	wake     chan struct{}
+	tokens   float64
+	last     time.Time
+}
+
+// Md5Scheduler serializes DataSignerMd5 calls of SingleHash and of the
+// stages that do not declare their own scheduler.
+var Md5Scheduler = NewScheduler(SchedulerConfig{Concurrency: 1})
+
+func NewScheduler(cfg SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		cfg:    cfg,
+		wake:   make(chan struct{}),
+		tokens: cfg.burst(),
+		last:   time.Now(),
+	}
+}
+
+func (s *Scheduler) SetConfig(cfg SchedulerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	if s.tokens > cfg.burst() {
+		s.tokens = cfg.burst()
+	}
+	s.broadcast()
+}
+
+func (s *Scheduler) broadcast() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+func (s *Scheduler) acquireSlot(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.inFlight < s.cfg.concurrency() {
+			s.inFlight++
+			s.mu.Unlock()
+			return nil
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Scheduler) releaseSlot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.broadcast()
+}
+
+// reserveToken takes a token from the bucket, possibly going into debt,
+// and returns how long to wait until the debt is paid off.
+func (s *Scheduler) reserveToken() (time.Duration, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := s.cfg.Rate
+	if rate <= 0 {
+		return 0, 0
+	}
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * rate
+	if s.tokens > s.cfg.burst() {
+		s.tokens = s.cfg.burst()
+	}
+	s.last = now
+
+	s.tokens--
+	if s.tokens >= 0 {
+		return 0, rate
+	}
+	return time.Duration(-s.tokens / rate * float64(time.Second)), rate
+}
+
+// Acquire blocks until a call may start. Every successful Acquire must be
+// followed by Release.
+func (s *Scheduler) Acquire(ctx context.Context) error {
+	if err := s.acquireSlot(ctx); err != nil {
+		return err
+	}
+
+	wait, rate := s.reserveToken()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.cfg.Rate == rate {
+			s.tokens++
+		}
+		s.mu.Unlock()
+		s.releaseSlot()
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) Release() {
+	s.releaseSlot()
+}
+
+func (s *Scheduler) Wrap(signer SignerFunc) SignerFunc {
+	return func(ctx context.Context, data string) (string, error) {
+		if err := s.Acquire(ctx); err != nil {
+			return "", err
+		}
+		defer s.Release()
+		return signer(ctx, data)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runScheduled(scheduler *Scheduler, calls int, delay time.Duration) int32 {
+	var running, maxRunning int32
+	signer := scheduler.Wrap(func(ctx context.Context, data string) (string, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		return data, nil
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			signer(context.Background(), "data")
+		}()
+	}
+	wg.Wait()
+	return maxRunning
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		scheduler := NewScheduler(SchedulerConfig{Concurrency: concurrency})
+		if got := runScheduled(scheduler, 20, time.Millisecond); got != int32(concurrency) {
+			t.Errorf("expected %d concurrent calls, got %d", concurrency, got)
+		}
+	}
+}
+
+func TestSchedulerRate(t *testing.T) {
+	scheduler := NewScheduler(SchedulerConfig{Concurrency: 10, Rate: 100, Burst: 2})
+
+	start := time.Now()
+	runScheduled(scheduler, 12, 0)
+	// 2 calls from the burst, 10 more at 10ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected rate limit to slow calls down, took %v", elapsed)
+	}
+}
+
+func TestSchedulerSetConfig(t *testing.T) {
+	scheduler := NewScheduler(SchedulerConfig{Concurrency: 1})
+	if err := scheduler.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- scheduler.Acquire(context.Background())
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected second call to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	scheduler.SetConfig(SchedulerConfig{Concurrency: 2})
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected waiting call to start after raising concurrency")
+	}
+	scheduler.Release()
+	scheduler.Release()
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	scheduler := NewScheduler(SchedulerConfig{Concurrency: 1})
+	scheduler.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := scheduler.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	scheduler.Release()
+
+	if err := scheduler.Acquire(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPoolMd5Scheduler(t *testing.T) {
+	useFastSigners(t)
+
+	var running, maxRunning int32
+	md5 := func(ctx context.Context, data string) (string, error) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return DataSignerMd5(data), nil
+	}
+
+	inputs := make([]int, 20)
+	cfg := PoolConfig{Workers: 10, Md5: md5, Md5Scheduler: NewScheduler(SchedulerConfig{Concurrency: 4})}
+	if _, err := RunStage(context.Background(), inputs, SingleHashPool(cfg)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxRunning < 2 || maxRunning > 4 {
+		t.Errorf("expected 2..4 concurrent md5 calls, got %d", maxRunning)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"sort"
 	"strconv"
 	"strings"
@@ -9,131 +11,262 @@ import (
 )
 
 func ExecutePipeline(jobs ...job) {
+	ExecutePipelineMetrics(nil, jobs...)
+}
+
+// ExecutePipelineMetrics runs the jobs like ExecutePipeline and, when metrics
+// is not nil, records per-stage statistics into it.
+func ExecutePipelineMetrics(metrics *PipelineMetrics, jobs ...job) {
 
 	wg := &sync.WaitGroup{}
-	defer wg.Wait()
+	var panics firstPanic
+
+	var stages []*stageMetrics
+	if metrics != nil {
+		stages = metrics.reset(jobs)
+	}
 
 	in := make(chan interface{}, 1)
 
-	for _, currentJob := range jobs {
+	for i, currentJob := range jobs {
 		wg.Add(1)
 		out := make(chan interface{}, 1)
 
+		if metrics != nil && i > 0 {
+			jobIn := make(chan interface{})
+			wg.Add(1)
+			go func(from, to chan interface{}, producer, consumer *stageMetrics) {
+				defer wg.Done()
+				forward(from, to, producer, consumer)
+			}(in, jobIn, stages[i-1], stages[i])
+			in = jobIn
+		}
+
 		go func(currentJob job, in, out chan interface{}) {
 			defer wg.Done()
 			defer close(out)
 
-			currentJob(in, out)
+			if err := callJob(currentJob, in, out); err != nil {
+				panics.keep(err.(*PanicError))
+				go drain(in)
+			}
 		}(currentJob, in, out)
 
 		in = out
 	}
+
+	if metrics != nil && len(jobs) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forward(in, nil, stages[len(stages)-1], nil)
+		}()
+	}
+
+	// a panicked job closes its output, the pipeline finishes and the panic
+	// goes on in the caller
+	wg.Wait()
+	panics.repanic()
+}
+
+func combineResults(arr []string) string {
+	sort.Strings(arr)
+	return strings.Join(arr, "_")
 }
 
 func CombineResults(in, out chan interface{}) {
+	combineResultsJob(in, out, getDeadLetterSink())
+}
+
+// CombineResultsDeadLetters is CombineResults sending malformed items to sink.
+func CombineResultsDeadLetters(sink DeadLetterSink) job {
+	return func(in, out chan interface{}) {
+		combineResultsJob(in, out, sink)
+	}
+}
+
+func combineResultsJob(in, out chan interface{}, sink DeadLetterSink) {
 	var arr []string
 
 	for elem := range in {
 		stringElem, ok := elem.(string)
 		if !ok {
-			fmt.Println("[ERROR]: failed convert data to int")
-			return
+			sink(DeadLetter{Stage: "CombineResults", Item: elem, Err: fmt.Errorf("CombineResults: expected %T, got %T", stringElem, elem)})
+			continue
 		}
 
 		arr = append(arr, stringElem)
 	}
 
-	sort.Strings(arr)
-	out <- strings.Join(arr, "_")
+	out <- combineResults(arr)
 }
 
-func getSingleHash(data string, wg *sync.WaitGroup, wgInside *sync.WaitGroup, mu *sync.Mutex, out chan interface{}) {
-	defer wg.Done()
-	fmt.Println(data + " SingleHash data " + data)
-	var dataCrc32Md5 string
+func defaultMd5(ctx context.Context, data string) (string, error) {
+	return DataSignerMd5(data), nil
+}
 
-	wgInside.Add(1)
+func defaultCrc32(ctx context.Context, data string) (string, error) {
+	return DataSignerCrc32(data), nil
+}
 
-	go func(data string) {
-		defer wgInside.Done()
+func signSingleHash(ctx context.Context, log *slog.Logger, recipe Recipe, data string, md5, crc32 SignerFunc) (string, error) {
+	log.Debug("hash step", "step", "data", "data", data)
+	group, ctx := newErrGroup(ctx)
+	var dataCrc32Md5, dataCrc32 string
 
-		mu.Lock()
-		dataMd5 := DataSignerMd5(data)
-		mu.Unlock()
-		fmt.Println(data + " SingleHash md5(data) " + dataMd5)
-		dataCrc32Md5 = DataSignerCrc32(dataMd5)
-		fmt.Println(data + " SingleHash crc32(md5(data)) " + dataCrc32Md5)
+	group.Go(func() error {
+		dataMd5, err := md5(ctx, data)
+		if err != nil {
+			return err
+		}
+		log.Debug("hash step", "step", "md5(data)", "data", data, "value", dataMd5)
+		dataCrc32Md5, err = crc32(ctx, dataMd5)
+		if err != nil {
+			return err
+		}
+		log.Debug("hash step", "step", "crc32(md5(data))", "data", data, "value", dataCrc32Md5)
+		return nil
+	})
+
+	group.Go(func() error {
+		var err error
+		dataCrc32, err = crc32(ctx, data)
+		if err != nil {
+			return err
+		}
+		log.Debug("hash step", "step", "crc32(data)", "data", data, "value", dataCrc32)
+		return nil
+	})
 
-	}(data)
+	if err := group.Wait(); err != nil {
+		return "", err
+	}
 
-	dataCrc32 := DataSignerCrc32(data)
-	fmt.Println(data + " SingleHash crc32(data) " + dataCrc32)
+	dataResult := dataCrc32 + recipe.Separator + dataCrc32Md5
+	log.Debug("hash step", "step", "result", "data", data, "value", dataResult)
 
-	wgInside.Wait()
+	return dataResult, nil
+}
 
-	dataResult := dataCrc32 + "~" + dataCrc32Md5
-	fmt.Println(data + " SingleHash result " + dataResult)
+func singleHash(log *slog.Logger, data string, md5, crc32 SignerFunc) string {
+	result, err := signSingleHash(context.Background(), log, DefaultRecipe, data, md5, crc32)
+	if err != nil {
+		// the legacy signers only fail by panicking, the panic goes on
+		panic(err)
+	}
+	return result
+}
 
-	out <- dataResult
+func getSingleHash(log *slog.Logger, data string, wg *sync.WaitGroup, panics *firstPanic, md5 SignerFunc, out chan interface{}) {
+	defer wg.Done()
+	defer panics.catch()
+	out <- singleHash(log, data, md5, defaultCrc32)
 }
 
 func SingleHash(in, out chan interface{}) {
+	singleHashJob(in, out, getDeadLetterSink())
+}
+
+// SingleHashDeadLetters is SingleHash sending malformed items to sink.
+func SingleHashDeadLetters(sink DeadLetterSink) job {
+	return func(in, out chan interface{}) {
+		singleHashJob(in, out, sink)
+	}
+}
+
+func singleHashJob(in, out chan interface{}, sink DeadLetterSink) {
 	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
+	var panics firstPanic
+	md5 := Md5Scheduler.Wrap(defaultMd5)
 
+	var item uint64
 	for input := range in {
 
-		wg.Add(1)
 		numberInput, ok := input.(int)
 		if !ok {
-			fmt.Println("[ERROR]: failed convert data to int")
-			return
+			sink(DeadLetter{Stage: "SingleHash", Item: input, Err: fmt.Errorf("SingleHash: expected %T, got %T", numberInput, input)})
+			continue
 		}
 
-		wgInside := &sync.WaitGroup{}
-		go getSingleHash(strconv.Itoa(numberInput), wg, wgInside, mu, out)
+		wg.Add(1)
+		go getSingleHash(itemLogger("SingleHash", item), strconv.Itoa(numberInput), wg, &panics, md5, out)
+		item++
 	}
 
 	wg.Wait()
+	panics.repanic()
 }
 
-func getMultiHash(data string, wg *sync.WaitGroup, out chan interface{}) {
-	defer wg.Done()
+func signMultiHash(ctx context.Context, log *slog.Logger, recipe Recipe, data string, crc32 SignerFunc) (string, error) {
+	group, ctx := newErrGroup(ctx)
+	var arrayHash = make([]string, recipe.Rounds, recipe.Rounds)
+
+	for i := 0; i < recipe.Rounds; i++ {
+		th := i
+		group.Go(func() error {
+			crcParam, err := crc32(ctx, strconv.Itoa(th)+data)
+			if err != nil {
+				return err
+			}
+			log.Debug("hash step", "step", "crc32(th+data)", "th", th, "data", data, "value", crcParam)
+			arrayHash[th] = crcParam
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return "", err
+	}
 
-	wgInside := &sync.WaitGroup{}
-	var arrayHash = make([]string, 6, 6)
+	result := strings.Join(arrayHash, recipe.RoundSeparator)
+	log.Debug("hash step", "step", "result", "data", data, "value", result)
 
-	for i := 0; i < 6; i++ {
-		wgInside.Add(1)
+	return result, nil
+}
 
-		go func(i int) {
-			defer wgInside.Done()
-			crcParam := DataSignerCrc32(strconv.Itoa(i) + data)
-			arrayHash[i] = crcParam
-		}(i)
+func multiHash(log *slog.Logger, data string, crc32 SignerFunc) string {
+	result, err := signMultiHash(context.Background(), log, DefaultRecipe, data, crc32)
+	if err != nil {
+		// the legacy signers only fail by panicking, the panic goes on
+		panic(err)
 	}
+	return result
+}
 
-	wgInside.Wait()
+func getMultiHash(log *slog.Logger, data string, wg *sync.WaitGroup, panics *firstPanic, out chan interface{}) {
+	defer wg.Done()
+	defer panics.catch()
+	out <- multiHash(log, data, defaultCrc32)
+}
 
-	result := strings.Join(arrayHash, "")
-	fmt.Println(data + " MultiHash: crc32(th+step1)) " + result)
+func MultiHash(in, out chan interface{}) {
+	multiHashJob(in, out, getDeadLetterSink())
+}
 
-	out <- result
+// MultiHashDeadLetters is MultiHash sending malformed items to sink.
+func MultiHashDeadLetters(sink DeadLetterSink) job {
+	return func(in, out chan interface{}) {
+		multiHashJob(in, out, sink)
+	}
 }
 
-func MultiHash(in, out chan interface{}) {
+func multiHashJob(in, out chan interface{}, sink DeadLetterSink) {
 	wg := &sync.WaitGroup{}
+	var panics firstPanic
+	var item uint64
 	for input := range in {
 
 		inputString, ok := input.(string)
 		if !ok {
-			fmt.Println("[ERROR]: failed convert data to int")
-			return
+			sink(DeadLetter{Stage: "MultiHash", Item: input, Err: fmt.Errorf("MultiHash: expected %T, got %T", inputString, input)})
+			continue
 		}
 		wg.Add(1)
 
-		go getMultiHash(inputString, wg, out)
+		go getMultiHash(itemLogger("MultiHash", item), inputString, wg, &panics, out)
+		item++
 	}
 
 	wg.Wait()
+	panics.repanic()
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Stage is a typed pipeline job: wiring stages with mismatched types fails
+// at compile time instead of killing a stage on a failed type assertion.
+// A stage must not close out, the caller does it once the stage returns.
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+func sendTo[T any](ctx context.Context, out chan<- T, value T) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain is started once a consumer stops reading in early, so the producer
+// can finish its pending sends instead of blocking forever.
+func drain[T any](in <-chan T) {
+	for range in {
+	}
+}
+
+// Chain connects the output of first to the input of second.
+func Chain[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		group, ctx := newErrGroup(ctx)
+		middle := make(chan B, 1)
+
+		group.Go(func() error {
+			defer close(middle)
+			return first(ctx, in, middle)
+		})
+		group.Go(func() error {
+			err := second(ctx, middle, out)
+			go drain(middle)
+			return err
+		})
+
+		return group.Wait()
+	}
+}
+
+// RunStage feeds inputs to stage and collects everything it emits.
+func RunStage[In, Out any](ctx context.Context, inputs []In, stage Stage[In, Out]) ([]Out, error) {
+	var results []Out
+	err := EachStage(ctx, inputs, stage, func(output Out) error {
+		results = append(results, output)
+		return nil
+	})
+	return results, err
+}
+
+// EachStage feeds inputs to stage and calls fn for everything it emits as
+// soon as it is emitted. An error from fn stops the stage.
+func EachStage[In, Out any](ctx context.Context, inputs []In, stage Stage[In, Out], fn func(output Out) error) error {
+	return eachStage(ctx, stage, fn, func(ctx context.Context, in chan<- In) error {
+		for _, input := range inputs {
+			if err := sendTo(ctx, in, input); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FeedStage is EachStage for inputs that arrive over time: every input is
+// passed to stage as soon as it is read from inputs, until inputs is closed.
+func FeedStage[In, Out any](ctx context.Context, inputs <-chan In, stage Stage[In, Out], fn func(output Out) error) error {
+	return eachStage(ctx, stage, fn, func(ctx context.Context, in chan<- In) error {
+		for {
+			select {
+			case input, ok := <-inputs:
+				if !ok {
+					return nil
+				}
+				if err := sendTo(ctx, in, input); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+func eachStage[In, Out any](ctx context.Context, stage Stage[In, Out], fn func(output Out) error, feed func(ctx context.Context, in chan<- In) error) error {
+	group, ctx := newErrGroup(ctx)
+	in := make(chan In, 1)
+	out := make(chan Out, 1)
+
+	group.Go(func() error {
+		defer close(in)
+		return feed(ctx, in)
+	})
+	group.Go(func() error {
+		defer close(out)
+		err := stage(ctx, in, out)
+		go drain(in)
+		return err
+	})
+	group.Go(func() error {
+		for output := range out {
+			if err := fn(output); err != nil {
+				go drain(out)
+				return err
+			}
+		}
+		return nil
+	})
+
+	return group.Wait()
+}
+
+// StageJob lets a typed stage run inside ExecutePipelineContext. An input of
+// the wrong type fails the pipeline with an error naming the stage.
+func StageJob[In, Out any](name string, stage Stage[In, Out]) contextJob {
+	return StageJobDeadLetters(name, stage, nil)
+}
+
+// StageJobDeadLetters is StageJob sending inputs of the wrong type to sink
+// and going on with the rest; a nil sink fails the pipeline like StageJob.
+func StageJobDeadLetters[In, Out any](name string, stage Stage[In, Out], sink DeadLetterSink) contextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		group, ctx := newErrGroup(ctx)
+		typedIn := make(chan In)
+		typedOut := make(chan Out)
+
+		group.Go(func() error {
+			defer close(typedIn)
+			for input := range in {
+				value, ok := input.(In)
+				if !ok {
+					err := fmt.Errorf("%s: expected %T, got %T", name, value, input)
+					if sink == nil {
+						return err
+					}
+					sink(DeadLetter{Stage: name, Item: input, Err: err})
+					continue
+				}
+				if err := sendTo(ctx, typedIn, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		group.Go(func() error {
+			defer close(typedOut)
+			err := stage(ctx, typedIn, typedOut)
+			go drain(typedIn)
+			return err
+		})
+		group.Go(func() error {
+			for output := range typedOut {
+				if err := send(ctx, out, output); err != nil {
+					go drain(typedOut)
+					return err
+				}
+			}
+			return nil
+		})
+
+		return group.Wait()
+	}
+}
+
+func SingleHashStage(ctx context.Context, in <-chan int, out chan<- string) error {
+	group, ctx := newErrGroup(ctx)
+	md5 := Md5Scheduler.Wrap(defaultMd5)
+
+	var item uint64
+	for input := range in {
+		data, log := strconv.Itoa(input), itemLogger("SingleHash", item)
+		group.Go(func() error {
+			return sendTo(ctx, out, singleHash(log, data, md5, defaultCrc32))
+		})
+		item++
+	}
+
+	return group.Wait()
+}
+
+func MultiHashStage(ctx context.Context, in <-chan string, out chan<- string) error {
+	group, ctx := newErrGroup(ctx)
+
+	var item uint64
+	for input := range in {
+		data, log := input, itemLogger("MultiHash", item)
+		group.Go(func() error {
+			return sendTo(ctx, out, multiHash(log, data, defaultCrc32))
+		})
+		item++
+	}
+
+	return group.Wait()
+}
+
+func CombineResultsStage(ctx context.Context, in <-chan string, out chan<- string) error {
+	var arr []string
+	for input := range in {
+		arr = append(arr, input)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return sendTo(ctx, out, combineResults(arr))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStageChain(t *testing.T) {
+	useFastSigners(t)
+
+	hashes := Chain(Chain(SingleHashStage, MultiHashStage), CombineResultsStage)
+	results, err := RunStage(context.Background(), []int{1, 0}, hashes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := testHash0 + "_" + testHash1; len(results) != 1 || results[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+}
+
+func TestStageChainError(t *testing.T) {
+	errStage := errors.New("stage failed")
+
+	failing := Stage[string, string](func(ctx context.Context, in <-chan string, out chan<- string) error {
+		for range in {
+			return errStage
+		}
+		return nil
+	})
+	double := Stage[int, string](func(ctx context.Context, in <-chan int, out chan<- string) error {
+		for input := range in {
+			if err := sendTo(ctx, out, string(rune('a'+input))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	_, err := RunStage(context.Background(), make([]int, 100), Chain(double, failing))
+	if err != errStage {
+		t.Errorf("expected %v, got %v", errStage, err)
+	}
+}
+
+func TestStageJobLegacyPipeline(t *testing.T) {
+	useFastSigners(t)
+
+	var result interface{}
+	err := ExecutePipelineContext(context.Background(),
+		withContext(func(in, out chan interface{}) {
+			out <- 0
+		}),
+		StageJob("SingleHash", SingleHashStage),
+		StageJob("MultiHash", MultiHashStage),
+		withContext(func(in, out chan interface{}) {
+			result = <-in
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != testHash0 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, testHash0)
+	}
+}
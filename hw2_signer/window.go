@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Windowed combiners emit combineResults of every window instead of
+// waiting for the input to end, so they also work on infinite streams.
+// Whatever is left in the window when the input closes is emitted too.
+
+func flushWindow(ctx context.Context, out chan<- string, window []string) error {
+	if len(window) == 0 {
+		return nil
+	}
+	return sendTo(ctx, out, combineResults(window))
+}
+
+// CountWindow combines every size consecutive results.
+func CountWindow(size int) Stage[string, string] {
+	if size < 1 {
+		size = 1
+	}
+	return func(ctx context.Context, in <-chan string, out chan<- string) error {
+		var window []string
+		for input := range in {
+			window = append(window, input)
+			if len(window) < size {
+				continue
+			}
+			if err := flushWindow(ctx, out, window); err != nil {
+				return err
+			}
+			window = nil
+		}
+		return flushWindow(ctx, out, window)
+	}
+}
+
+// minWindow is the shortest period or gap of the time based windows, shorter
+// ones are raised to it the same way CountWindow raises its size to 1.
+const minWindow = time.Millisecond
+
+// TimeWindow combines the results received during each period, empty
+// periods emit nothing.
+func TimeWindow(period time.Duration) Stage[string, string] {
+	if period < minWindow {
+		period = minWindow
+	}
+	return timeWindow(func() (<-chan time.Time, func()) {
+		ticker := time.NewTicker(period)
+		return ticker.C, ticker.Stop
+	})
+}
+
+// timeWindow emits the window on every tick of the clock started by start.
+func timeWindow(start func() (ticks <-chan time.Time, stop func())) Stage[string, string] {
+	return func(ctx context.Context, in <-chan string, out chan<- string) error {
+		ticks, stop := start()
+		defer stop()
+
+		var window []string
+		for {
+			select {
+			case input, ok := <-in:
+				if !ok {
+					return flushWindow(ctx, out, window)
+				}
+				window = append(window, input)
+			case <-ticks:
+				if err := flushWindow(ctx, out, window); err != nil {
+					return err
+				}
+				window = nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// SessionWindow combines results until no new one arrives for gap.
+func SessionWindow(gap time.Duration) Stage[string, string] {
+	if gap < minWindow {
+		gap = minWindow
+	}
+	return func(ctx context.Context, in <-chan string, out chan<- string) error {
+		timer := time.NewTimer(gap)
+		timer.Stop()
+		defer timer.Stop()
+
+		var window []string
+		var expired <-chan time.Time
+		var last time.Time
+		for {
+			select {
+			case input, ok := <-in:
+				if !ok {
+					return flushWindow(ctx, out, window)
+				}
+				window = append(window, input)
+				last = time.Now()
+				if expired == nil {
+					timer.Reset(gap)
+					expired = timer.C
+				}
+			case <-expired:
+				// the timer is not reset on every item, only once it fires
+				if wait := gap - time.Since(last); wait > 0 {
+					timer.Reset(wait)
+					continue
+				}
+				expired = nil
+				if err := flushWindow(ctx, out, window); err != nil {
+					return err
+				}
+				window = nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type timedInput struct {
+	value string
+	delay time.Duration
+}
+
+func runWindow(t *testing.T, stage Stage[string, string], inputs []timedInput) []string {
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for _, input := range inputs {
+			time.Sleep(input.delay)
+			in <- input.value
+		}
+	}()
+
+	out := make(chan string, len(inputs))
+	if err := stage(context.Background(), in, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(out)
+
+	var results []string
+	for result := range out {
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestCountWindow(t *testing.T) {
+	inputs := []timedInput{{value: "c"}, {value: "a"}, {value: "b"}, {value: "e"}, {value: "d"}}
+	results := runWindow(t, CountWindow(2), inputs)
+	if expected := []string{"a_c", "b_e", "d"}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+}
+
+func TestTimeWindow(t *testing.T) {
+	ticks := make(chan time.Time)
+	stage := timeWindow(func() (<-chan time.Time, func()) { return ticks, func() {} })
+
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		// the channels are unbuffered, so every send is received in order
+		in <- "b"
+		in <- "a"
+		ticks <- time.Now()
+		ticks <- time.Now()
+		in <- "d"
+		in <- "c"
+	}()
+
+	out := make(chan string, 3)
+	if err := stage(context.Background(), in, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(out)
+
+	var results []string
+	for result := range out {
+		results = append(results, result)
+	}
+	if expected := []string{"a_b", "c_d"}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+}
+
+func TestWindowNonPositiveDuration(t *testing.T) {
+	inputs := []timedInput{{value: "a"}, {value: "b"}, {value: "c"}}
+	for name, stage := range map[string]Stage[string, string]{
+		"TimeWindow":    TimeWindow(0),
+		"SessionWindow": SessionWindow(-time.Second),
+	} {
+		var values []string
+		for _, result := range runWindow(t, stage, inputs) {
+			values = append(values, strings.Split(result, "_")...)
+		}
+		sort.Strings(values)
+		if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(values, expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, values)
+		}
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	inputs := []timedInput{
+		{value: "b"},
+		{value: "a", delay: 20 * time.Millisecond},
+		{value: "c", delay: 20 * time.Millisecond},
+		{value: "e", delay: 150 * time.Millisecond},
+		{value: "d", delay: 20 * time.Millisecond},
+	}
+	results := runWindow(t, SessionWindow(80*time.Millisecond), inputs)
+	if expected := []string{"a_b_c", "d_e"}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+}
+
+func TestWindowPipeline(t *testing.T) {
+	useFastSigners(t)
+
+	results, err := RunStage(context.Background(), []int{1, 0, 0, 1}, Chain(Chain(SingleHashPool(PoolConfig{Ordered: true}), MultiHashPool(PoolConfig{Ordered: true})), CountWindow(2)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{testHash0 + "_" + testHash1, testHash0 + "_" + testHash1}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+}